@@ -1,316 +1,263 @@
 package common
 
 import (
-	"bytes"
-	"crypto/md5"
+	"context"
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
-	"regexp"
 	"strings"
 	"time"
 )
 
 // ProxyRequest represents incoming proxy request
 type ProxyRequest struct {
-	URL      string                 `json:"url"`
-	Method   string                 `json:"method"`
-	Username string                 `json:"username"`
-	Password string                 `json:"password"`
-	Body     map[string]interface{} `json:"body,omitempty"`
+	URL      string      `json:"url"`
+	Method   string      `json:"method"`
+	Username string      `json:"username"`
+	Password string      `json:"password"`
+	Body     interface{} `json:"body,omitempty"` // Any JSON value; interpretation depends on BodyEncoding
+	// CredentialID refers to a vault credential set used instead of Username/Password
+	CredentialID string `json:"credentialId,omitempty"`
+	// BodyEncoding selects how Body is sent: json (default), raw, base64 or form
+	BodyEncoding string `json:"bodyEncoding,omitempty"`
+	// ContentType overrides the default Content-Type for BodyEncoding
+	ContentType string `json:"contentType,omitempty"`
+	// LegacyResponse returns only Data (top-level JSON object or {"text": ...}) like older versions
+	LegacyResponse bool `json:"legacyResponse,omitempty"`
+	// Headers are extra request headers sent to the camera (Authorization is ignored)
+	Headers map[string]string `json:"headers,omitempty"`
+	// Query parameters merged into the URL query string
+	Query map[string]string `json:"query,omitempty"`
+	// ProbeTimeoutMs overrides the 3s unauthenticated probe timeout
+	ProbeTimeoutMs int `json:"probeTimeoutMs,omitempty"`
+	// TimeoutMs overrides the client timeout for authenticated requests (e.g. firmware upgrades)
+	TimeoutMs int `json:"timeoutMs,omitempty"`
 }
 
 // ProxyResponse represents proxy response
 type ProxyResponse struct {
 	Status int                    `json:"status,omitempty"`
-	Data   map[string]interface{} `json:"data,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"` // Top-level JSON object, or {"text": body} for other text
 	Error  string                 `json:"error,omitempty"`
+	// ErrorCode classifies Error (see ErrCode* constants)
+	ErrorCode ErrorCode `json:"errorCode,omitempty"`
+	// Passthrough of the camera response (omitted for LegacyResponse requests)
+	Headers      http.Header `json:"headers,omitempty"`
+	ContentType  string      `json:"contentType,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"bodyEncoding,omitempty"` // "text" or "base64"
 }
 
-// DigestChallenge represents HTTP Digest authentication challenge
-type DigestChallenge struct {
-	Realm     string
-	Nonce     string
-	Opaque    string
-	Algorithm string
-	Qop       string
-}
+// CertificateVerifier checks a camera's TLS connection
+// addr is the dialed ip:port, host is the host:port from the request URL
+// For cameras in TrustModeChain it runs after chain validation, with cs.VerifiedChains set
+type CertificateVerifier func(addr, host string, cs tls.ConnectionState) error
 
 // CreateHTTPClient creates an HTTP client configured for camera connections
-// Accepts self-signed certificates with fingerprint validation
-func CreateHTTPClient(timeout time.Duration, verifyFn func(cs tls.ConnectionState) error) *http.Client {
+// Certificates are checked by verifyFn (fingerprint pinning), or by chain validation
+// for cameras cameraTLS puts in TrustModeChain, which still pass through verifyFn so their
+// certificate metadata is recorded; cameraTLS also supplies client certificates
+func CreateHTTPClient(timeout time.Duration, cameraTLS *CameraTLS, verifyFn CertificateVerifier) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}
+
 	return &http.Client{
 		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true, // We validate fingerprints in VerifyConnection
-				VerifyConnection:   verifyFn,
+			DialContext: dialer.DialContext,
+			DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialCameraTLS(ctx, dialer, network, addr, cameraTLS, verifyFn)
 			},
 		},
 		Timeout: timeout,
 	}
 }
 
-// TryUnauthenticatedRequest makes ONE request without auth (3 second timeout)
-// This is Step 1 of the Electron pattern - quickly detect non-cameras
-func TryUnauthenticatedRequest(client *http.Client, req *ProxyRequest) (ProxyResponse, error) {
-	var bodyReader io.Reader
-	var bodyBytes []byte
-	if req.Body != nil && len(req.Body) > 0 {
-		var err error
-		bodyBytes, err = json.Marshal(req.Body)
-		if err != nil {
-			return ProxyResponse{}, fmt.Errorf("failed to marshal request body: %w", err)
-		}
-		bodyReader = bytes.NewReader(bodyBytes)
+// dialCameraTLS dials addr and completes the TLS handshake, passing the
+// actually dialed ip:port to verifyFn so pins don't depend on the SNI name
+func dialCameraTLS(ctx context.Context, dialer *net.Dialer, network, addr string, cameraTLS *CameraTLS, verifyFn CertificateVerifier) (net.Conn, error) {
+	rawConn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
 	}
 
-	httpReq, err := http.NewRequest(req.Method, req.URL, bodyReader)
+	serverName, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		return ProxyResponse{}, fmt.Errorf("failed to create request: %w", err)
+		serverName = addr
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("User-Agent", "AnaVision/1.0")
-	httpReq.Header.Set("X-Requested-With", "XMLHttpRequest")
-
-	if bodyBytes != nil {
-		httpReq.Header.Set("Content-Length", fmt.Sprintf("%d", len(bodyBytes)))
+	remoteAddr := rawConn.RemoteAddr().String()
+	var remoteIP net.IP
+	if tcpAddr, ok := rawConn.RemoteAddr().(*net.TCPAddr); ok {
+		remoteIP = tcpAddr.IP
 	}
 
-	// Use 3 second timeout for this test (same as Electron)
-	testClient := &http.Client{
-		Transport: client.Transport,
-		Timeout:   3 * time.Second,
+	config := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true, // We validate fingerprints in VerifyConnection
 	}
-
-	httpResp, err := testClient.Do(httpReq)
-	if err != nil {
-		return ProxyResponse{}, err
-	}
-	defer httpResp.Body.Close()
-
-	return ParseResponse(httpResp)
-}
-
-// TryBasicAuth attempts HTTP Basic authentication
-func TryBasicAuth(client *http.Client, req *ProxyRequest) (ProxyResponse, error) {
-	var bodyReader io.Reader
-	var bodyBytes []byte
-	if req.Body != nil && len(req.Body) > 0 {
-		var err error
-		bodyBytes, err = json.Marshal(req.Body)
-		if err != nil {
-			return ProxyResponse{}, fmt.Errorf("failed to marshal request body: %w", err)
-		}
-		bodyReader = bytes.NewReader(bodyBytes)
+	if clientCert := cameraTLS.ClientCertificate(serverName, remoteIP); clientCert != nil {
+		// mTLS: device management interfaces that require a client certificate
+		config.Certificates = []tls.Certificate{*clientCert}
 	}
-
-	httpReq, err := http.NewRequest(req.Method, req.URL, bodyReader)
-	if err != nil {
-		return ProxyResponse{}, fmt.Errorf("failed to create request: %w", err)
+	if cameraTLS.Mode(serverName, remoteIP) == TrustModeChain {
+		// Enterprise PKI / device ID certificates: full chain and hostname validation
+		config.InsecureSkipVerify = false
+		config.RootCAs = cameraTLS.Roots()
 	}
-
-	httpReq.SetBasicAuth(req.Username, req.Password)
-	if req.Body != nil {
-		httpReq.Header.Set("Content-Type", "application/json")
-		if bodyBytes != nil {
-			httpReq.Header.Set("Content-Length", fmt.Sprintf("%d", len(bodyBytes)))
+	if verifyFn != nil {
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyFn(remoteAddr, addr, cs)
 		}
 	}
 
-	httpResp, err := client.Do(httpReq)
-	if err != nil {
-		return ProxyResponse{}, fmt.Errorf("request execution failed: %w", err)
+	tlsConn := tls.Client(rawConn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		rawConn.Close()
+		return nil, err
 	}
-	defer httpResp.Body.Close()
-
-	return ParseResponse(httpResp)
+	return tlsConn, nil
 }
 
-// TryDigestAuth attempts HTTP Digest authentication
-// CRITICAL: Sends body in BOTH challenge and authenticated requests
-func TryDigestAuth(client *http.Client, req *ProxyRequest) (ProxyResponse, error) {
-	// First request to get challenge (send body for Axis cameras that process it)
-	var bodyBytes []byte
-	var bodyReader io.Reader
-	if req.Body != nil && len(req.Body) > 0 {
-		var err error
-		bodyBytes, err = json.Marshal(req.Body)
-		if err != nil {
-			return ProxyResponse{}, fmt.Errorf("failed to marshal request body: %w", err)
-		}
-		bodyReader = bytes.NewReader(bodyBytes)
-	}
-
-	httpReq, err := http.NewRequest(req.Method, req.URL, bodyReader)
-	if err != nil {
-		return ProxyResponse{}, fmt.Errorf("failed to create initial request: %w", err)
-	}
+// TryUnauthenticatedRequest makes ONE request without auth (3 second timeout)
+// This is Step 1 of the Electron pattern - quickly detect non-cameras
+// The probe timeout only covers waiting for the response headers; reading the body of a
+// 200 is bounded by req.TimeoutMs like any other final response
+// All Try* helpers abort as soon as ctx is cancelled (e.g. the browser dropped the call)
+func TryUnauthenticatedRequest(ctx context.Context, client *http.Client, req *ProxyRequest) (ProxyResponse, error) {
+	// Use 3 second timeout for this test (same as Electron) unless the caller overrides it
+	probeTimeoutMs := req.ProbeTimeoutMs
+	if probeTimeoutMs <= 0 {
+		probeTimeoutMs = int(DefaultProbeTimeout / time.Millisecond)
+	}
+	return tryWithoutAuth(ctx, client, req, time.Duration(probeTimeoutMs)*time.Millisecond)
+}
 
-	if bodyBytes != nil {
-		httpReq.Header.Set("Content-Type", "application/json")
-		httpReq.Header.Set("Content-Length", fmt.Sprintf("%d", len(bodyBytes)))
-	}
+// TryNoAuthRequest makes a request without auth to a camera known not to need it
+// Unlike TryUnauthenticatedRequest it is only bounded by req.TimeoutMs
+func TryNoAuthRequest(ctx context.Context, client *http.Client, req *ProxyRequest) (ProxyResponse, error) {
+	return tryWithoutAuth(ctx, client, req, 0)
+}
 
-	httpResp, err := client.Do(httpReq)
+// tryWithoutAuth sends req without credentials; a non-zero headerTimeout limits the wait
+// for the response headers
+func tryWithoutAuth(ctx context.Context, client *http.Client, req *ProxyRequest, headerTimeout time.Duration) (ProxyResponse, error) {
+	bodyBytes, contentType, err := EncodeRequestBody(req)
 	if err != nil {
-		return ProxyResponse{}, fmt.Errorf("initial request failed: %w", err)
-	}
-	defer httpResp.Body.Close()
-
-	if httpResp.StatusCode != 401 {
-		return ParseResponse(httpResp)
+		return ProxyResponse{}, NewProxyError(ErrCodeBadRequest, err)
 	}
 
-	// Parse WWW-Authenticate header
-	authHeader := httpResp.Header.Get("WWW-Authenticate")
-	if authHeader == "" {
-		return ProxyResponse{}, fmt.Errorf("no WWW-Authenticate header in response")
-	}
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// Parse Digest challenge
-	challenge, err := ParseDigestChallenge(authHeader)
+	httpReq, err := newCameraRequest(reqCtx, req, bodyBytes, contentType)
 	if err != nil {
-		return ProxyResponse{}, fmt.Errorf("failed to parse Digest challenge: %w", err)
+		return ProxyResponse{}, NewProxyError(ErrCodeBadRequest, fmt.Errorf("failed to create request: %w", err))
 	}
 
-	// Calculate Digest response
-	digestAuth := CalculateDigestAuth(req, challenge)
-
-	// Make authenticated request (recreate body bytes for second request)
-	bodyBytes = nil
-	bodyReader = nil
-	if req.Body != nil && len(req.Body) > 0 {
-		var err error
-		bodyBytes, err = json.Marshal(req.Body)
-		if err != nil {
-			return ProxyResponse{}, fmt.Errorf("failed to marshal request body: %w", err)
-		}
-		bodyReader = bytes.NewReader(bodyBytes)
-	}
+	httpReq.Header.Set("Content-Type", contentType)
+	httpReq.Header.Set("User-Agent", "AnaVision/1.0")
+	httpReq.Header.Set("X-Requested-With", "XMLHttpRequest")
+	applyCustomHeaders(httpReq, req)
 
-	httpReq2, err := http.NewRequest(req.Method, req.URL, bodyReader)
-	if err != nil {
-		return ProxyResponse{}, fmt.Errorf("failed to create authenticated request: %w", err)
+	var headerTimer *time.Timer
+	if headerTimeout > 0 {
+		headerTimer = time.AfterFunc(headerTimeout, cancel)
 	}
 
-	httpReq2.Header.Set("Authorization", digestAuth)
-	if req.Body != nil {
-		httpReq2.Header.Set("Content-Type", "application/json")
-		if bodyBytes != nil {
-			httpReq2.Header.Set("Content-Length", fmt.Sprintf("%d", len(bodyBytes)))
+	httpResp, err := clientWithTimeout(client, req.TimeoutMs).Do(httpReq)
+	if headerTimer != nil && !headerTimer.Stop() {
+		// Fired before (or right as) the headers arrived - the request is cancelled either way
+		if err == nil {
+			httpResp.Body.Close()
 		}
+		return ProxyResponse{}, fmt.Errorf("no response within %v: %w", headerTimeout, context.DeadlineExceeded)
 	}
-
-	httpResp2, err := client.Do(httpReq2)
 	if err != nil {
-		return ProxyResponse{}, fmt.Errorf("authenticated request failed: %w", err)
+		return ProxyResponse{}, err
 	}
-	defer httpResp2.Body.Close()
+	defer httpResp.Body.Close()
 
-	return ParseResponse(httpResp2)
+	return ParseResponse(httpResp, req.LegacyResponse)
 }
 
-// ParseDigestChallenge parses WWW-Authenticate header for Digest auth
-func ParseDigestChallenge(header string) (*DigestChallenge, error) {
-	if !strings.HasPrefix(header, "Digest ") {
-		return nil, fmt.Errorf("not a Digest challenge")
+// TryBasicAuth attempts HTTP Basic authentication
+func TryBasicAuth(ctx context.Context, client *http.Client, req *ProxyRequest) (ProxyResponse, error) {
+	bodyBytes, contentType, err := EncodeRequestBody(req)
+	if err != nil {
+		return ProxyResponse{}, NewProxyError(ErrCodeBadRequest, err)
 	}
 
-	challenge := &DigestChallenge{
-		Algorithm: "MD5",
+	httpReq, err := newCameraRequest(ctx, req, bodyBytes, contentType)
+	if err != nil {
+		return ProxyResponse{}, NewProxyError(ErrCodeBadRequest, fmt.Errorf("failed to create request: %w", err))
 	}
 
-	re := regexp.MustCompile(`(\w+)="([^"]+)"`)
-	matches := re.FindAllStringSubmatch(header, -1)
-
-	for _, match := range matches {
-		key := match[1]
-		value := match[2]
-
-		switch strings.ToLower(key) {
-		case "realm":
-			challenge.Realm = value
-		case "nonce":
-			challenge.Nonce = value
-		case "opaque":
-			challenge.Opaque = value
-		case "algorithm":
-			challenge.Algorithm = value
-		case "qop":
-			challenge.Qop = value
-		}
-	}
+	httpReq.SetBasicAuth(req.Username, req.Password)
+	applyCustomHeaders(httpReq, req)
 
-	if challenge.Realm == "" || challenge.Nonce == "" {
-		return nil, fmt.Errorf("missing required Digest parameters")
+	httpResp, err := clientWithTimeout(client, req.TimeoutMs).Do(httpReq)
+	if err != nil {
+		return ProxyResponse{}, fmt.Errorf("request execution failed: %w", err)
 	}
+	defer httpResp.Body.Close()
 
-	return challenge, nil
+	return ParseResponse(httpResp, req.LegacyResponse)
 }
 
-// CalculateDigestAuth calculates Digest authorization header
-func CalculateDigestAuth(req *ProxyRequest, challenge *DigestChallenge) string {
-	uri := req.URL
-	if idx := strings.Index(uri, "://"); idx != -1 {
-		uri = uri[idx+3:]
-		if idx := strings.Index(uri, "/"); idx != -1 {
-			uri = uri[idx:]
-		} else {
-			uri = "/"
-		}
-	}
-
-	ha1 := md5Hash(fmt.Sprintf("%s:%s:%s", req.Username, challenge.Realm, req.Password))
-	ha2 := md5Hash(fmt.Sprintf("%s:%s", req.Method, uri))
-
-	// Generate secure random client nonce
-	cnonce := generateSecureNonce()
-	nc := "00000001" // Nonce count - could be incremented for multiple requests
-
-	var response string
-	if challenge.Qop == "" {
-		response = md5Hash(fmt.Sprintf("%s:%s:%s", ha1, challenge.Nonce, ha2))
-	} else {
-		response = md5Hash(fmt.Sprintf("%s:%s:%s:%s:%s:%s", ha1, challenge.Nonce, nc, cnonce, challenge.Qop, ha2))
-	}
-
-	auth := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
-		req.Username, challenge.Realm, challenge.Nonce, uri, response)
-
-	if challenge.Opaque != "" {
-		auth += fmt.Sprintf(`, opaque="%s"`, challenge.Opaque)
-	}
-
-	if challenge.Algorithm != "" {
-		auth += fmt.Sprintf(`, algorithm=%s`, challenge.Algorithm)
+// TryDigestAuth attempts HTTP Digest authentication
+// CRITICAL: Sends body in BOTH challenge and authenticated requests
+// cache may be nil; when set, a cached challenge is reused with an incremented nonce count
+func TryDigestAuth(ctx context.Context, client *http.Client, cache *DigestCache, req *ProxyRequest) (ProxyResponse, error) {
+	// Send body on every attempt (Axis cameras process it on the challenge request too)
+	bodyBytes, contentType, err := EncodeRequestBody(req)
+	if err != nil {
+		return ProxyResponse{}, NewProxyError(ErrCodeBadRequest, err)
 	}
 
-	if challenge.Qop != "" {
-		auth += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, challenge.Qop, nc, cnonce)
+	httpResp, err := DoDigestRequest(ctx, clientWithTimeout(client, req.TimeoutMs), cache, req, bodyBytes, func(ctx context.Context) (*http.Request, error) {
+		httpReq, err := newCameraRequest(ctx, req, bodyBytes, contentType)
+		if err != nil {
+			return nil, err
+		}
+		applyCustomHeaders(httpReq, req)
+		return httpReq, nil
+	})
+	if err != nil {
+		return ProxyResponse{}, err
 	}
+	defer httpResp.Body.Close()
 
-	return auth
+	return ParseResponse(httpResp, req.LegacyResponse)
 }
 
 // ParseResponse converts HTTP response to ProxyResponse
-func ParseResponse(httpResp *http.Response) (ProxyResponse, error) {
+// Data keeps a top-level JSON object (or {"text": body} for other text) for older clients;
+// unless legacy is set, headers, content type and the raw body are passed through as well
+// (binary bodies are base64-encoded and never copied into Data)
+func ParseResponse(httpResp *http.Response, legacy bool) (ProxyResponse, error) {
 	bodyBytes, err := io.ReadAll(httpResp.Body)
 	if err != nil {
 		return ProxyResponse{}, fmt.Errorf("failed to read response body: %w", err)
 	}
 
+	contentType := httpResp.Header.Get("Content-Type")
+	isText := isTextBody(contentType, bodyBytes)
+
 	resp := ProxyResponse{
 		Status: httpResp.StatusCode,
 		Data:   make(map[string]interface{}),
 	}
 
-	if len(bodyBytes) > 0 {
+	if len(bodyBytes) > 0 && (isText || legacy) {
 		var jsonData map[string]interface{}
 		if err := json.Unmarshal(bodyBytes, &jsonData); err == nil {
 			resp.Data = jsonData
@@ -319,7 +266,26 @@ func ParseResponse(httpResp *http.Response) (ProxyResponse, error) {
 		}
 	}
 
+	if !legacy {
+		resp.Headers = httpResp.Header
+		resp.ContentType = contentType
+		if len(bodyBytes) > 0 {
+			if isText {
+				resp.Body = string(bodyBytes)
+				resp.BodyEncoding = "text"
+			} else {
+				resp.Body = base64.StdEncoding.EncodeToString(bodyBytes)
+				resp.BodyEncoding = "base64"
+			}
+		}
+	}
+
 	if httpResp.StatusCode >= 400 {
+		resp.ErrorCode = ErrCodeCameraHTTPError
+		if httpResp.StatusCode == 401 || httpResp.StatusCode == 403 {
+			resp.ErrorCode = ErrCodeAuthFailed
+		}
+
 		if msg, ok := resp.Data["error"].(string); ok {
 			resp.Error = msg
 		} else if text, ok := resp.Data["text"].(string); ok {
@@ -332,25 +298,6 @@ func ParseResponse(httpResp *http.Response) (ProxyResponse, error) {
 	return resp, nil
 }
 
-// IsTimeoutError checks if error is a timeout
-func IsTimeoutError(err error) bool {
-	if err == nil {
-		return false
-	}
-	return strings.Contains(err.Error(), "timeout") ||
-		strings.Contains(err.Error(), "ETIMEDOUT") ||
-		strings.Contains(err.Error(), "context deadline exceeded")
-}
-
-// IsConnectionRefusedError checks if error is connection refused
-func IsConnectionRefusedError(err error) bool {
-	if err == nil {
-		return false
-	}
-	return strings.Contains(err.Error(), "connection refused") ||
-		strings.Contains(err.Error(), "ECONNREFUSED")
-}
-
 // SanitizeCredential redacts sensitive credential information for logging
 // Shows first and last character only, e.g., "anava" -> "a***a"
 func SanitizeCredential(credential string) string {
@@ -384,9 +331,3 @@ func generateSecureNonce() string {
 	}
 	return hex.EncodeToString(b)
 }
-
-// md5Hash calculates MD5 hash for Digest authentication
-func md5Hash(input string) string {
-	hash := md5.Sum([]byte(input))
-	return fmt.Sprintf("%x", hash)
-}
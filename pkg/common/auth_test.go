@@ -0,0 +1,153 @@
+package common
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		name             string
+		status           int
+		contentType      string
+		body             string
+		legacy           bool
+		wantBody         string
+		wantBodyEncoding string
+		wantData         map[string]interface{}
+		wantError        string
+		wantErrorCode    ErrorCode
+	}{
+		{
+			name:             "json object",
+			status:           200,
+			contentType:      "application/json",
+			body:             `{"apiVersion":"1.0","data":{"propertyList":{"Brand":"AXIS"}}}`,
+			wantBody:         `{"apiVersion":"1.0","data":{"propertyList":{"Brand":"AXIS"}}}`,
+			wantBodyEncoding: "text",
+			wantData:         map[string]interface{}{"apiVersion": "1.0", "data": map[string]interface{}{"propertyList": map[string]interface{}{"Brand": "AXIS"}}},
+		},
+		{
+			name:             "plain text",
+			status:           200,
+			contentType:      "text/plain",
+			body:             "root.Brand.Brand=AXIS\n",
+			wantBody:         "root.Brand.Brand=AXIS\n",
+			wantBodyEncoding: "text",
+			wantData:         map[string]interface{}{"text": "root.Brand.Brand=AXIS\n"},
+		},
+		{
+			name:             "binary",
+			status:           200,
+			contentType:      "image/jpeg",
+			body:             "\xff\xd8\xff\xe0",
+			wantBody:         base64.StdEncoding.EncodeToString([]byte("\xff\xd8\xff\xe0")),
+			wantBodyEncoding: "base64",
+			wantData:         map[string]interface{}{},
+		},
+		{
+			// Declared binary types stay base64 even when the bytes are valid UTF-8
+			name:             "binary type with utf-8 bytes",
+			status:           200,
+			contentType:      "application/octet-stream",
+			body:             "abc",
+			wantBody:         "YWJj",
+			wantBodyEncoding: "base64",
+			wantData:         map[string]interface{}{},
+		},
+		{
+			name:             "no content type, sniffed as text",
+			status:           200,
+			body:             "OK",
+			wantBody:         "OK",
+			wantBodyEncoding: "text",
+			wantData:         map[string]interface{}{"text": "OK"},
+		},
+		{
+			name:             "no content type, sniffed as binary",
+			status:           200,
+			body:             "\x00\xff",
+			wantBody:         "AP8=",
+			wantBodyEncoding: "base64",
+			wantData:         map[string]interface{}{},
+		},
+		{
+			name:     "empty body",
+			status:   204,
+			wantData: map[string]interface{}{},
+		},
+		{
+			// Legacy responses only carry Data, whatever the content type
+			name:        "legacy binary",
+			status:      200,
+			contentType: "image/jpeg",
+			body:        "\xff\xd8",
+			legacy:      true,
+			wantData:    map[string]interface{}{"text": "\xff\xd8"},
+		},
+		{
+			name:             "camera error message",
+			status:           500,
+			contentType:      "application/json",
+			body:             `{"error":"Invalid parameter"}`,
+			wantBody:         `{"error":"Invalid parameter"}`,
+			wantBodyEncoding: "text",
+			wantData:         map[string]interface{}{"error": "Invalid parameter"},
+			wantError:        "Invalid parameter",
+			wantErrorCode:    ErrCodeCameraHTTPError,
+		},
+		{
+			name:             "unauthorized",
+			status:           401,
+			contentType:      "text/html",
+			body:             "Unauthorized",
+			wantBody:         "Unauthorized",
+			wantBodyEncoding: "text",
+			wantData:         map[string]interface{}{"text": "Unauthorized"},
+			wantError:        "Unauthorized",
+			wantErrorCode:    ErrCodeAuthFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpResp := &http.Response{
+				StatusCode: tt.status,
+				Status:     http.StatusText(tt.status),
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+			}
+			if tt.contentType != "" {
+				httpResp.Header.Set("Content-Type", tt.contentType)
+			}
+
+			resp, err := ParseResponse(httpResp, tt.legacy)
+			if err != nil {
+				t.Fatalf("ParseResponse() error: %v", err)
+			}
+			if resp.Status != tt.status {
+				t.Errorf("Status = %d, want %d", resp.Status, tt.status)
+			}
+			if resp.Body != tt.wantBody || resp.BodyEncoding != tt.wantBodyEncoding {
+				t.Errorf("Body = %q (%q), want %q (%q)", resp.Body, resp.BodyEncoding, tt.wantBody, tt.wantBodyEncoding)
+			}
+			if !reflect.DeepEqual(resp.Data, tt.wantData) {
+				t.Errorf("Data = %v, want %v", resp.Data, tt.wantData)
+			}
+			if resp.Error != tt.wantError || resp.ErrorCode != tt.wantErrorCode {
+				t.Errorf("Error = %q (%q), want %q (%q)", resp.Error, resp.ErrorCode, tt.wantError, tt.wantErrorCode)
+			}
+			if tt.legacy {
+				if resp.Headers != nil || resp.ContentType != "" {
+					t.Errorf("legacy response carries Headers %v / ContentType %q", resp.Headers, resp.ContentType)
+				}
+			} else if resp.ContentType != tt.contentType {
+				t.Errorf("ContentType = %q, want %q", resp.ContentType, tt.contentType)
+			}
+		})
+	}
+}
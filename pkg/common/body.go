@@ -0,0 +1,162 @@
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Body encodings accepted in ProxyRequest.BodyEncoding
+const (
+	BodyEncodingJSON   = "json"   // Body is any JSON value, sent as application/json (default)
+	BodyEncodingRaw    = "raw"    // Body is a string, sent as-is (XML, plain text, ...)
+	BodyEncodingBase64 = "base64" // Body is a base64 string, decoded and sent as bytes
+	BodyEncodingForm   = "form"   // Body is an object or pre-encoded string, sent url-encoded
+)
+
+// defaultContentTypes maps each body encoding to the Content-Type used when the caller sets none
+var defaultContentTypes = map[string]string{
+	BodyEncodingJSON:   "application/json",
+	BodyEncodingRaw:    "text/plain; charset=utf-8",
+	BodyEncodingBase64: "application/octet-stream",
+	BodyEncodingForm:   "application/x-www-form-urlencoded",
+}
+
+// EncodeRequestBody converts req.Body to wire bytes according to req.BodyEncoding
+// Returns nil bytes when there is no body, plus the Content-Type to send
+func EncodeRequestBody(req *ProxyRequest) ([]byte, string, error) {
+	encoding := req.BodyEncoding
+	if encoding == "" {
+		encoding = BodyEncodingJSON
+	}
+
+	contentType, ok := defaultContentTypes[encoding]
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported body encoding: %s", req.BodyEncoding)
+	}
+	if req.ContentType != "" {
+		contentType = req.ContentType
+	}
+
+	if req.Body == nil {
+		return nil, contentType, nil
+	}
+
+	switch encoding {
+	case BodyEncodingRaw:
+		text, ok := req.Body.(string)
+		if !ok {
+			return nil, "", fmt.Errorf("raw body must be a string")
+		}
+		return []byte(text), contentType, nil
+
+	case BodyEncodingBase64:
+		encoded, ok := req.Body.(string)
+		if !ok {
+			return nil, "", fmt.Errorf("base64 body must be a string")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode base64 body: %w", err)
+		}
+		return decoded, contentType, nil
+
+	case BodyEncodingForm:
+		form, err := encodeForm(req.Body)
+		if err != nil {
+			return nil, "", err
+		}
+		return []byte(form), contentType, nil
+
+	default:
+		// Empty objects were never sent as a body - keep that for older clients
+		if obj, ok := req.Body.(map[string]interface{}); ok && len(obj) == 0 {
+			return nil, contentType, nil
+		}
+		bodyBytes, err := json.Marshal(req.Body)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		return bodyBytes, contentType, nil
+	}
+}
+
+// encodeForm url-encodes a pre-encoded string or an object whose values are
+// scalars or arrays of scalars (arrays become repeated keys)
+func encodeForm(body interface{}) (string, error) {
+	switch v := body.(type) {
+	case string:
+		return v, nil
+	case map[string]interface{}:
+		values := url.Values{}
+		for key := range v {
+			if list, ok := v[key].([]interface{}); ok {
+				for _, item := range list {
+					value, err := formValue(item)
+					if err != nil {
+						return "", fmt.Errorf("form field %s: %w", key, err)
+					}
+					values.Add(key, value)
+				}
+				continue
+			}
+			value, err := formValue(v[key])
+			if err != nil {
+				return "", fmt.Errorf("form field %s: %w", key, err)
+			}
+			values.Add(key, value)
+		}
+		return values.Encode(), nil
+	default:
+		return "", fmt.Errorf("form body must be an object or string")
+	}
+}
+
+// formValue converts a decoded JSON scalar to its form representation
+func formValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+// isTextBody reports whether a response body can be passed through as text
+// Uses the Content-Type when present, otherwise checks for valid UTF-8
+func isTextBody(contentType string, body []byte) bool {
+	if contentType == "" {
+		return utf8.Valid(body)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return utf8.Valid(body)
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"),
+		mediaType == "application/json",
+		mediaType == "application/xml",
+		mediaType == "application/javascript",
+		mediaType == "application/x-www-form-urlencoded":
+		return true
+	default:
+		return false
+	}
+}
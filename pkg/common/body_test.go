@@ -0,0 +1,178 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEncodeRequestBody(t *testing.T) {
+	tests := []struct {
+		name            string
+		request         string // ProxyRequest JSON, as sent by the extension
+		wantBody        string
+		wantNil         bool
+		wantContentType string
+		wantErr         bool
+	}{
+		{
+			name:            "no body",
+			request:         `{}`,
+			wantNil:         true,
+			wantContentType: "application/json",
+		},
+		{
+			name:            "json object",
+			request:         `{"body":{"apiVersion":"1.0","method":"getDeviceInfo"}}`,
+			wantBody:        `{"apiVersion":"1.0","method":"getDeviceInfo"}`,
+			wantContentType: "application/json",
+		},
+		{
+			name:            "json array",
+			request:         `{"body":[1,2,3],"bodyEncoding":"json"}`,
+			wantBody:        `[1,2,3]`,
+			wantContentType: "application/json",
+		},
+		{
+			name:            "json string",
+			request:         `{"body":"hello"}`,
+			wantBody:        `"hello"`,
+			wantContentType: "application/json",
+		},
+		{
+			name:            "empty json object is not sent",
+			request:         `{"body":{}}`,
+			wantNil:         true,
+			wantContentType: "application/json",
+		},
+		{
+			name:            "raw",
+			request:         `{"body":"<root><item/></root>","bodyEncoding":"raw","contentType":"application/xml"}`,
+			wantBody:        `<root><item/></root>`,
+			wantContentType: "application/xml",
+		},
+		{
+			name:            "raw default content type",
+			request:         `{"body":"root=1","bodyEncoding":"raw"}`,
+			wantBody:        `root=1`,
+			wantContentType: "text/plain; charset=utf-8",
+		},
+		{
+			name:    "raw must be a string",
+			request: `{"body":{"a":1},"bodyEncoding":"raw"}`,
+			wantErr: true,
+		},
+		{
+			name:            "base64",
+			request:         `{"body":"AAH/","bodyEncoding":"base64"}`,
+			wantBody:        "\x00\x01\xff",
+			wantContentType: "application/octet-stream",
+		},
+		{
+			name:    "base64 must decode",
+			request: `{"body":"not base64!","bodyEncoding":"base64"}`,
+			wantErr: true,
+		},
+		{
+			name:    "base64 must be a string",
+			request: `{"body":42,"bodyEncoding":"base64"}`,
+			wantErr: true,
+		},
+		{
+			name:            "form object",
+			request:         `{"body":{"action":"update","root.Image.I0.Enabled":true,"fps":15,"empty":null},"bodyEncoding":"form"}`,
+			wantBody:        `action=update&empty=&fps=15&root.Image.I0.Enabled=true`,
+			wantContentType: "application/x-www-form-urlencoded",
+		},
+		{
+			name:            "form array repeats the key",
+			request:         `{"body":{"id":[1,2.5,"x y"]},"bodyEncoding":"form"}`,
+			wantBody:        `id=1&id=2.5&id=x+y`,
+			wantContentType: "application/x-www-form-urlencoded",
+		},
+		{
+			name:            "form pre-encoded string",
+			request:         `{"body":"a=1&b=two","bodyEncoding":"form"}`,
+			wantBody:        `a=1&b=two`,
+			wantContentType: "application/x-www-form-urlencoded",
+		},
+		{
+			name:    "form nested object",
+			request: `{"body":{"a":{"b":1}},"bodyEncoding":"form"}`,
+			wantErr: true,
+		},
+		{
+			name:    "form must be an object or string",
+			request: `{"body":[1,2],"bodyEncoding":"form"}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown encoding",
+			request: `{"body":"x","bodyEncoding":"gzip"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req ProxyRequest
+			if err := json.Unmarshal([]byte(tt.request), &req); err != nil {
+				t.Fatalf("invalid test request: %v", err)
+			}
+
+			body, contentType, err := EncodeRequestBody(&req)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("EncodeRequestBody() = %q, want error", body)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EncodeRequestBody() error: %v", err)
+			}
+			if tt.wantNil {
+				if body != nil {
+					t.Errorf("body = %q, want nil", body)
+				}
+			} else if string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if contentType != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", contentType, tt.wantContentType)
+			}
+		})
+	}
+}
+
+func TestIsTextBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        bool
+	}{
+		{"plain text", "text/plain", "root.Brand.Brand=AXIS", true},
+		{"html with charset", "text/html; charset=ISO-8859-1", "<html></html>", true},
+		{"json", "application/json", `{"a":1}`, true},
+		{"json suffix", "application/problem+json", `{"title":"x"}`, true},
+		{"xml", "application/xml", "<a/>", true},
+		{"xml suffix", "application/soap+xml; charset=utf-8", "<Envelope/>", true},
+		{"javascript", "application/javascript", "var a;", true},
+		{"form", "application/x-www-form-urlencoded", "a=1", true},
+		{"upper case media type", "TEXT/PLAIN", "ok", true},
+		// A declared binary type wins even when the bytes happen to be valid UTF-8
+		{"jpeg", "image/jpeg", "abc", false},
+		{"octet stream", "application/octet-stream", "abc", false},
+		{"no content type, utf-8", "", "Hällo", true},
+		{"no content type, binary", "", "\xff\xd8\xff\xe0", false},
+		{"invalid content type, utf-8", "text/", "ok", true},
+		{"invalid content type, binary", "text/", "\xff\xd8", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTextBody(tt.contentType, []byte(tt.body)); got != tt.want {
+				t.Errorf("isTextBody(%q, %q) = %v, want %v", tt.contentType, tt.body, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const cameraTLSFileName = "camera-tls.json"
+
+// TrustMode selects how a camera's TLS certificate is validated
+type TrustMode string
+
+const (
+	// TrustModePin accepts self-signed certificates and relies on fingerprint pinning
+	TrustModePin TrustMode = "pin"
+	// TrustModeChain requires a certificate chain to the system roots or the CA bundle
+	TrustModeChain TrustMode = "chain"
+)
+
+// CameraTLSConfig is the on-disk format of ~/.config/anava/camera-tls.json
+type CameraTLSConfig struct {
+	CABundle string    `json:"caBundle,omitempty"` // PEM file of extra CAs trusted for chain validation
+	Default  TrustMode `json:"default,omitempty"`  // Mode for cameras no rule matches (default: pin)
+	// Client certificate (PEM files) presented to cameras no rule gives one
+	ClientCert string          `json:"clientCert,omitempty"`
+	ClientKey  string          `json:"clientKey,omitempty"`
+	Rules      []CameraTLSRule `json:"rules,omitempty"` // First match wins
+}
+
+// CameraTLSRule sets the trust mode and client certificate for matching cameras
+// Empty fields fall back to the top-level settings
+type CameraTLSRule struct {
+	Match      string    `json:"match"` // IP, CIDR, hostname or *.domain
+	Mode       TrustMode `json:"mode,omitempty"`
+	ClientCert string    `json:"clientCert,omitempty"`
+	ClientKey  string    `json:"clientKey,omitempty"`
+}
+
+// CameraTLS decides per camera whether certificates are chain-validated or pinned
+// A nil *CameraTLS pins every camera
+type CameraTLS struct {
+	roots         *x509.CertPool
+	defaultMode   TrustMode
+	defaultClient *tls.Certificate
+	rules         []cameraTLSRule
+	caBundle      string
+}
+
+// cameraTLSRule is a parsed CameraTLSRule
+type cameraTLSRule struct {
+	matcher    cameraMatcher
+	mode       TrustMode        // "" = default mode
+	clientCert *tls.Certificate // nil = default client certificate
+}
+
+// cameraMatcher matches a camera by hostname or dialed IP
+type cameraMatcher struct {
+	network  *net.IPNet
+	ip       net.IP
+	hostname string // exact name, or ".domain" for *.domain
+}
+
+// LoadCameraTLS reads camera-tls.json from ~/.config/anava
+// A missing file keeps the legacy behaviour (pin every camera)
+func LoadCameraTLS() (*CameraTLS, error) {
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, cameraTLSFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read camera TLS config: %w", err)
+	}
+
+	var config CameraTLSConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse camera TLS config: %w", err)
+	}
+
+	return NewCameraTLS(&config)
+}
+
+// NewCameraTLS validates config and loads its CA bundle
+func NewCameraTLS(config *CameraTLSConfig) (*CameraTLS, error) {
+	ct := &CameraTLS{
+		defaultMode: TrustModePin,
+		caBundle:    config.CABundle,
+	}
+
+	if config.Default != "" {
+		mode, err := parseTrustMode(config.Default)
+		if err != nil {
+			return nil, err
+		}
+		ct.defaultMode = mode
+	}
+
+	clientCert, err := loadClientCertificate(config.ClientCert, config.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+	ct.defaultClient = clientCert
+
+	for _, rule := range config.Rules {
+		matcher, err := parseCameraMatcher(rule.Match)
+		if err != nil {
+			return nil, err
+		}
+		parsed := cameraTLSRule{matcher: matcher}
+		if rule.Mode != "" {
+			parsed.mode, err = parseTrustMode(rule.Mode)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", rule.Match, err)
+			}
+		}
+		parsed.clientCert, err = loadClientCertificate(rule.ClientCert, rule.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Match, err)
+		}
+		ct.rules = append(ct.rules, parsed)
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+	if config.CABundle != "" {
+		pem, err := os.ReadFile(config.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", config.CABundle)
+		}
+	}
+	ct.roots = roots
+
+	return ct, nil
+}
+
+// Mode returns the trust mode for a camera requested as host and dialed at ip
+func (ct *CameraTLS) Mode(host string, ip net.IP) TrustMode {
+	if ct == nil {
+		return TrustModePin
+	}
+
+	if rule := ct.match(host, ip); rule != nil && rule.mode != "" {
+		return rule.mode
+	}
+	return ct.defaultMode
+}
+
+// ClientCertificate returns the client certificate to present to a camera requested
+// as host and dialed at ip, or nil for none
+func (ct *CameraTLS) ClientCertificate(host string, ip net.IP) *tls.Certificate {
+	if ct == nil {
+		return nil
+	}
+
+	if rule := ct.match(host, ip); rule != nil && rule.clientCert != nil {
+		return rule.clientCert
+	}
+	return ct.defaultClient
+}
+
+// match returns the first rule matching the camera, or nil
+func (ct *CameraTLS) match(host string, ip net.IP) *cameraTLSRule {
+	for i := range ct.rules {
+		if ct.rules[i].matcher.matches(host, ip) {
+			return &ct.rules[i]
+		}
+	}
+	return nil
+}
+
+// Roots returns the pool used for chain validation (system roots plus the CA bundle)
+func (ct *CameraTLS) Roots() *x509.CertPool {
+	if ct == nil {
+		return nil
+	}
+	return ct.roots
+}
+
+// String summarizes the configuration for logs
+func (ct *CameraTLS) String() string {
+	if ct == nil {
+		return "pin all cameras"
+	}
+	bundle := ct.caBundle
+	if bundle == "" {
+		bundle = "system roots only"
+	}
+	client := "none"
+	if ct.defaultClient != nil {
+		client = "configured"
+	}
+	return fmt.Sprintf("default %s, %d rules, CA bundle: %s, client certificate: %s", ct.defaultMode, len(ct.rules), bundle, client)
+}
+
+// loadClientCertificate loads a PEM certificate/key pair; both empty means none
+func loadClientCertificate(certFile, keyFile string) (*tls.Certificate, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("clientCert and clientKey must be set together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+// parseTrustMode validates a trust mode name
+func parseTrustMode(mode TrustMode) (TrustMode, error) {
+	switch TrustMode(strings.ToLower(string(mode))) {
+	case TrustModePin:
+		return TrustModePin, nil
+	case TrustModeChain:
+		return TrustModeChain, nil
+	default:
+		return "", fmt.Errorf("unknown trust mode: %s", mode)
+	}
+}
+
+// parseCameraMatcher parses an IP, CIDR, hostname or *.domain pattern
+func parseCameraMatcher(match string) (cameraMatcher, error) {
+	match = strings.ToLower(strings.TrimSpace(match))
+	if match == "" {
+		return cameraMatcher{}, fmt.Errorf("empty camera match")
+	}
+
+	if strings.Contains(match, "/") {
+		_, network, err := net.ParseCIDR(match)
+		if err != nil {
+			return cameraMatcher{}, fmt.Errorf("invalid subnet %q: %w", match, err)
+		}
+		return cameraMatcher{network: network}, nil
+	}
+	if ip := net.ParseIP(match); ip != nil {
+		return cameraMatcher{ip: ip}, nil
+	}
+	return cameraMatcher{hostname: strings.TrimPrefix(match, "*")}, nil
+}
+
+// matches reports whether the camera requested as host and dialed at ip matches
+func (m cameraMatcher) matches(host string, ip net.IP) bool {
+	switch {
+	case m.network != nil:
+		return ip != nil && m.network.Contains(ip)
+	case m.ip != nil:
+		return ip != nil && m.ip.Equal(ip)
+	case strings.HasPrefix(m.hostname, "."):
+		return strings.HasSuffix(strings.ToLower(host), m.hostname)
+	default:
+		return strings.EqualFold(host, m.hostname)
+	}
+}
@@ -0,0 +1,453 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate is a generated certificate and its key, for TLS tests
+type testCertificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCertificate issues a certificate for commonName (and 127.0.0.1), signed by
+// parent or self-signed when parent is nil
+func newTestCertificate(t *testing.T, commonName string, parent *testCertificate, isCA bool) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{commonName}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// write stores the certificate and key as PEM files in dir
+func (c *testCertificate) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, c.certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, c.keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// tlsCertificate returns the certificate as a tls.Certificate for a test server
+func (c *testCertificate) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCameraMatcher(t *testing.T) {
+	tests := []struct {
+		match string
+		host  string
+		ip    string
+		want  bool
+	}{
+		{"192.168.1.10", "camera.local", "192.168.1.10", true},
+		{"192.168.1.10", "192.168.1.10", "192.168.1.11", false},
+		{"192.168.1.10", "192.168.1.10", "", false}, // IP rules only match the dialed address
+		{"192.168.1.0/24", "camera.local", "192.168.1.200", true},
+		{"192.168.1.0/24", "camera.local", "192.168.2.1", false},
+		{"fd00::/8", "camera.local", "fd00::10", true},
+		{"camera.local", "camera.local", "192.168.1.10", true},
+		{"camera.local", "CAMERA.local", "", true},
+		{"camera.local", "other.local", "", false},
+		{"*.cameras.example", "lobby.cameras.example", "", true},
+		{"*.cameras.example", "a.b.cameras.example", "", true},
+		{"*.cameras.example", "cameras.example", "", false},
+		{"*.cameras.example", "evilcameras.example", "", false},
+		{" *.Cameras.Example ", "lobby.cameras.example", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.match+" "+tt.host+" "+tt.ip, func(t *testing.T) {
+			matcher, err := parseCameraMatcher(tt.match)
+			if err != nil {
+				t.Fatalf("parseCameraMatcher(%q): %v", tt.match, err)
+			}
+			if got := matcher.matches(tt.host, net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("matches(%q, %q) = %v, want %v", tt.host, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCameraTLSMode(t *testing.T) {
+	rules := []CameraTLSRule{
+		{Match: "192.168.1.10", Mode: TrustModePin},
+		{Match: "192.168.1.0/24", Mode: TrustModeChain},
+		{Match: "*.pki.example", Mode: "CHAIN"},
+		{Match: "legacy.pki.example"}, // Shadowed by the rule above
+		{Match: "nomode.example"},     // No mode: falls back to the default
+	}
+
+	tests := []struct {
+		name   string
+		config *CameraTLSConfig // nil = no camera-tls.json
+		host   string
+		ip     string
+		want   TrustMode
+	}{
+		{name: "no config pins", config: nil, host: "192.168.1.10", ip: "192.168.1.10", want: TrustModePin},
+		{name: "empty config pins", config: &CameraTLSConfig{}, host: "camera.local", ip: "10.0.0.1", want: TrustModePin},
+		{name: "chain default", config: &CameraTLSConfig{Default: TrustModeChain}, host: "camera.local", ip: "10.0.0.1", want: TrustModeChain},
+		{name: "first match wins", config: &CameraTLSConfig{Rules: rules}, host: "192.168.1.10", ip: "192.168.1.10", want: TrustModePin},
+		{name: "subnet rule", config: &CameraTLSConfig{Rules: rules}, host: "192.168.1.20", ip: "192.168.1.20", want: TrustModeChain},
+		{name: "wildcard rule, mode is case-insensitive", config: &CameraTLSConfig{Rules: rules}, host: "legacy.pki.example", ip: "10.0.0.1", want: TrustModeChain},
+		{name: "rule without mode uses the default", config: &CameraTLSConfig{Default: TrustModeChain, Rules: rules}, host: "nomode.example", ip: "10.0.0.1", want: TrustModeChain},
+		{name: "no rule matches", config: &CameraTLSConfig{Rules: rules}, host: "camera.local", ip: "10.0.0.1", want: TrustModePin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ct *CameraTLS
+			if tt.config != nil {
+				var err error
+				ct, err = NewCameraTLS(tt.config)
+				if err != nil {
+					t.Fatalf("NewCameraTLS: %v", err)
+				}
+			}
+			if got := ct.Mode(tt.host, net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("Mode(%q, %q) = %q, want %q", tt.host, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCameraTLSErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "not-pem.crt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  CameraTLSConfig
+		wantErr string
+	}{
+		{"unknown default mode", CameraTLSConfig{Default: "trust-all"}, "unknown trust mode"},
+		{"unknown rule mode", CameraTLSConfig{Rules: []CameraTLSRule{{Match: "camera.local", Mode: "none"}}}, "unknown trust mode"},
+		{"empty match", CameraTLSConfig{Rules: []CameraTLSRule{{Match: " "}}}, "empty camera match"},
+		{"invalid subnet", CameraTLSConfig{Rules: []CameraTLSRule{{Match: "192.168.1.0/33"}}}, "invalid subnet"},
+		{"missing CA bundle", CameraTLSConfig{CABundle: filepath.Join(dir, "missing.pem")}, "failed to read CA bundle"},
+		{"CA bundle without certificates", CameraTLSConfig{CABundle: notPEM}, "no certificates found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCameraTLS(&tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewCameraTLS() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadCameraTLS(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// No camera-tls.json: pin every camera
+	ct, err := LoadCameraTLS()
+	if err != nil || ct != nil {
+		t.Fatalf("LoadCameraTLS() without a file = %v, %v; want nil, nil", ct, err)
+	}
+
+	path := filepath.Join(home, ".config", "anava", cameraTLSFileName)
+	if err := os.WriteFile(path, []byte(`{"default":"chain","rules":[{"match":"10.0.0.0/8","mode":"pin"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ct, err = LoadCameraTLS()
+	if err != nil {
+		t.Fatalf("LoadCameraTLS: %v", err)
+	}
+	if got := ct.Mode("camera.local", net.ParseIP("10.1.2.3")); got != TrustModePin {
+		t.Errorf("Mode(10.1.2.3) = %q, want pin", got)
+	}
+	if got := ct.Mode("camera.local", net.ParseIP("192.168.1.10")); got != TrustModeChain {
+		t.Errorf("Mode(192.168.1.10) = %q, want chain", got)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"default":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCameraTLS(); err == nil {
+		t.Error("LoadCameraTLS() accepted invalid JSON")
+	}
+}
+
+func TestCameraTLSChainValidation(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, "Test Camera CA", nil, true)
+	bundle, _ := ca.write(t, dir, "ca")
+
+	signed := newTestCertificate(t, "camera.local", ca, false)
+	selfSigned := newTestCertificate(t, "camera.local", nil, false)
+
+	tests := []struct {
+		name   string
+		mode   TrustMode
+		server *testCertificate
+		// wantVerified is whether the pin callback sees a verified chain
+		wantVerified bool
+		wantErr      bool
+	}{
+		{name: "chain, signed by the CA bundle", mode: TrustModeChain, server: signed, wantVerified: true},
+		{name: "chain, self-signed", mode: TrustModeChain, server: selfSigned, wantErr: true},
+		{name: "pin, self-signed", mode: TrustModePin, server: selfSigned, wantVerified: false},
+		// Pin mode never builds a chain, even for a certificate the bundle would trust
+		{name: "pin, signed by the CA bundle", mode: TrustModePin, server: signed, wantVerified: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			server.TLS = &tls.Config{Certificates: []tls.Certificate{tt.server.tlsCertificate(t)}}
+			server.StartTLS()
+			defer server.Close()
+
+			ct, err := NewCameraTLS(&CameraTLSConfig{CABundle: bundle, Default: tt.mode})
+			if err != nil {
+				t.Fatalf("NewCameraTLS: %v", err)
+			}
+
+			var verified, called bool
+			client := CreateHTTPClient(5*time.Second, ct, func(addr, host string, cs tls.ConnectionState) error {
+				called = true
+				verified = len(cs.VerifiedChains) > 0
+				return nil
+			})
+			resp, err := client.Get(server.URL)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("request succeeded, want a certificate error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+			if !called {
+				t.Fatal("certificate verifier was not called")
+			}
+			if verified != tt.wantVerified {
+				t.Errorf("verified chain = %v, want %v", verified, tt.wantVerified)
+			}
+		})
+	}
+}
+
+func TestCameraTLSClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	defaultCert, defaultKey := newTestCertificate(t, "default-client", nil, false).write(t, dir, "default")
+	ruleCert, ruleKey := newTestCertificate(t, "rule-client", nil, false).write(t, dir, "rule")
+
+	config := &CameraTLSConfig{
+		ClientCert: defaultCert,
+		ClientKey:  defaultKey,
+		Rules: []CameraTLSRule{
+			{Match: "192.168.1.0/24", ClientCert: ruleCert, ClientKey: ruleKey},
+			{Match: "chain.example", Mode: TrustModeChain}, // No certificate: falls back to the default
+		},
+	}
+
+	tests := []struct {
+		name   string
+		config *CameraTLSConfig
+		host   string
+		ip     string
+		want   string // Common name of the presented certificate, "" for none
+	}{
+		{name: "no config", config: nil, host: "192.168.1.10", ip: "192.168.1.10", want: ""},
+		{name: "no client certificate configured", config: &CameraTLSConfig{}, host: "192.168.1.10", ip: "192.168.1.10", want: ""},
+		{name: "rule certificate", config: config, host: "192.168.1.10", ip: "192.168.1.10", want: "rule-client"},
+		{name: "rule without certificate", config: config, host: "chain.example", ip: "10.0.0.1", want: "default-client"},
+		{name: "no rule matches", config: config, host: "camera.local", ip: "10.0.0.1", want: "default-client"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ct *CameraTLS
+			if tt.config != nil {
+				var err error
+				ct, err = NewCameraTLS(tt.config)
+				if err != nil {
+					t.Fatalf("NewCameraTLS: %v", err)
+				}
+			}
+
+			got := ""
+			if cert := ct.ClientCertificate(tt.host, net.ParseIP(tt.ip)); cert != nil {
+				leaf, err := x509.ParseCertificate(cert.Certificate[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = leaf.Subject.CommonName
+			}
+			if got != tt.want {
+				t.Errorf("ClientCertificate(%q, %q) = %q, want %q", tt.host, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCameraTLSClientCertificateErrors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newTestCertificate(t, "client", nil, false).write(t, dir, "client")
+	_, otherKey := newTestCertificate(t, "other", nil, false).write(t, dir, "other")
+
+	tests := []struct {
+		name    string
+		config  CameraTLSConfig
+		wantErr string
+	}{
+		{"certificate without key", CameraTLSConfig{ClientCert: certFile}, "must be set together"},
+		{"key without certificate", CameraTLSConfig{ClientKey: keyFile}, "must be set together"},
+		{"mismatched key", CameraTLSConfig{ClientCert: certFile, ClientKey: otherKey}, "failed to load client certificate"},
+		{"missing file", CameraTLSConfig{ClientCert: filepath.Join(dir, "missing.crt"), ClientKey: keyFile}, "failed to load client certificate"},
+		{"rule certificate without key", CameraTLSConfig{Rules: []CameraTLSRule{{Match: "camera.local", ClientCert: certFile}}}, `rule "camera.local"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCameraTLS(&tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewCameraTLS() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCameraTLSMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCA := newTestCertificate(t, "Test Client CA", nil, true)
+	clientCert, clientKey := newTestCertificate(t, "vms-client", clientCA, false).write(t, dir, "client")
+	serverCert := newTestCertificate(t, "camera.local", nil, false)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCA.cert)
+
+	// The camera's management interface requires a client certificate from clientCA
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert.tlsCertificate(t)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		rules   []CameraTLSRule
+		wantErr bool
+	}{
+		{name: "certificate for the camera", rules: []CameraTLSRule{{Match: "127.0.0.1", ClientCert: clientCert, ClientKey: clientKey}}},
+		{name: "certificate for another camera", rules: []CameraTLSRule{{Match: "192.168.1.10", ClientCert: clientCert, ClientKey: clientKey}}, wantErr: true},
+		{name: "no certificate", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ct, err := NewCameraTLS(&CameraTLSConfig{Rules: tt.rules})
+			if err != nil {
+				t.Fatalf("NewCameraTLS: %v", err)
+			}
+
+			client := CreateHTTPClient(5*time.Second, ct, nil)
+			resp, err := client.Get(server.URL)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("request succeeded without the client certificate")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != "vms-client" {
+				t.Errorf("camera saw client certificate %q, want vms-client", body)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // Config represents the configuration stored on disk
@@ -12,6 +13,35 @@ type Config struct {
 	BackendURL   string `json:"backendUrl"`
 	ProjectID    string `json:"projectId"`
 	SessionToken string `json:"sessionToken"`
+	// CertPinPolicy is the proxy certificate pin policy: warn (default), tofu or pre-approved
+	CertPinPolicy string `json:"certPinPolicy,omitempty"`
+	// AllowedOrigins are browser origins trusted with vault credentials and certificate
+	// changes in addition to the built-in list (e.g. "chrome-extension://<id>")
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"`
+}
+
+// PinPolicy controls how unknown and changed camera certificates are handled
+type PinPolicy string
+
+const (
+	// PinPolicyWarn pins on first use and only logs mismatches
+	PinPolicyWarn PinPolicy = "warn"
+	// PinPolicyTOFU pins on first use and blocks mismatches
+	PinPolicyTOFU PinPolicy = "tofu"
+	// PinPolicyPreApproved only allows certificates pinned beforehand (/certs/accept)
+	PinPolicyPreApproved PinPolicy = "pre-approved"
+)
+
+// ParsePinPolicy validates a policy name; empty means PinPolicyWarn
+func ParsePinPolicy(name string) (PinPolicy, error) {
+	switch policy := PinPolicy(strings.ToLower(strings.TrimSpace(name))); policy {
+	case "":
+		return PinPolicyWarn, nil
+	case PinPolicyWarn, PinPolicyTOFU, PinPolicyPreApproved:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown certificate pin policy: %s", name)
+	}
 }
 
 // ConfigStorage handles persistent configuration
@@ -21,19 +51,29 @@ type ConfigStorage struct {
 
 // NewConfigStorage creates a new config storage instance
 func NewConfigStorage() (*ConfigStorage, error) {
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConfigStorage{
+		filePath: filepath.Join(dir, "connector-config.json"),
+	}, nil
+}
+
+// configDir returns ~/.config/anava, creating it if needed
+func configDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
+		return "", fmt.Errorf("failed to get home directory: %w", err)
 	}
 
-	configDir := filepath.Join(homeDir, ".config", "anava")
-	if err := os.MkdirAll(configDir, 0700); err != nil {
-		return nil, fmt.Errorf("failed to create config directory: %w", err)
+	dir := filepath.Join(homeDir, ".config", "anava")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create config directory: %w", err)
 	}
 
-	return &ConfigStorage{
-		filePath: filepath.Join(configDir, "connector-config.json"),
-	}, nil
+	return dir, nil
 }
 
 // Load reads configuration from disk
@@ -0,0 +1,379 @@
+package common
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+)
+
+// DigestChallenge represents HTTP Digest authentication challenge (RFC 7616)
+type DigestChallenge struct {
+	Realm     string
+	Nonce     string
+	Opaque    string
+	Algorithm string
+	Qop       []string // qop options offered by the server, e.g. ["auth", "auth-int"]
+	Stale     bool
+	UserHash  bool
+	Charset   string // "UTF-8" when the server expects UTF-8 credentials (RFC 7616 section 4)
+}
+
+// digestAlgorithms maps RFC 7616 algorithm names to hash constructors
+var digestAlgorithms = map[string]func() hash.Hash{
+	"MD5":         md5.New,
+	"SHA-256":     sha256.New,
+	"SHA-512-256": sha512.New512_256,
+}
+
+// digestAlgorithmPreference orders algorithms from strongest to weakest
+// Used when a server offers several Digest challenges in one 401
+var digestAlgorithmPreference = []string{
+	"SHA-512-256",
+	"SHA-512-256-SESS",
+	"SHA-256",
+	"SHA-256-SESS",
+	"MD5",
+	"MD5-SESS",
+}
+
+// ParseDigestChallenge parses WWW-Authenticate header for Digest auth
+// Handles quoted and unquoted parameters, qop lists, stale, userhash and charset
+func ParseDigestChallenge(header string) (*DigestChallenge, error) {
+	header = strings.TrimSpace(header)
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Digest ") {
+		return nil, fmt.Errorf("not a Digest challenge")
+	}
+
+	challenge := &DigestChallenge{
+		Algorithm: "MD5",
+	}
+
+	params := parseAuthParams(header[7:])
+	for key, value := range params {
+		switch key {
+		case "realm":
+			challenge.Realm = value
+		case "nonce":
+			challenge.Nonce = value
+		case "opaque":
+			challenge.Opaque = value
+		case "algorithm":
+			challenge.Algorithm = value
+		case "qop":
+			for _, option := range strings.Split(value, ",") {
+				if option = strings.TrimSpace(option); option != "" {
+					challenge.Qop = append(challenge.Qop, strings.ToLower(option))
+				}
+			}
+		case "stale":
+			challenge.Stale = strings.EqualFold(value, "true")
+		case "userhash":
+			challenge.UserHash = strings.EqualFold(value, "true")
+		case "charset":
+			challenge.Charset = value
+		}
+	}
+
+	if challenge.Realm == "" || challenge.Nonce == "" {
+		return nil, fmt.Errorf("missing required Digest parameters")
+	}
+
+	if !challenge.Supported() {
+		return nil, fmt.Errorf("unsupported Digest algorithm: %s", challenge.Algorithm)
+	}
+
+	return challenge, nil
+}
+
+// SelectDigestChallenge picks the strongest supported Digest challenge
+// from all WWW-Authenticate header values of a 401 response
+func SelectDigestChallenge(headers []string) (*DigestChallenge, error) {
+	var challenges []*DigestChallenge
+	var lastErr error
+
+	for _, header := range headers {
+		for _, part := range splitChallenges(header) {
+			challenge, err := ParseDigestChallenge(part)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			challenges = append(challenges, challenge)
+		}
+	}
+
+	if len(challenges) == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no WWW-Authenticate header in response")
+		}
+		return nil, lastErr
+	}
+
+	for _, preferred := range digestAlgorithmPreference {
+		for _, challenge := range challenges {
+			if strings.ToUpper(challenge.Algorithm) == preferred {
+				return challenge, nil
+			}
+		}
+	}
+
+	return challenges[0], nil
+}
+
+// Supported reports whether the challenge uses an algorithm we can compute
+func (c *DigestChallenge) Supported() bool {
+	_, ok := digestAlgorithms[c.baseAlgorithm()]
+	return ok
+}
+
+// SelectQop chooses the qop value for the response
+// Prefers "auth" for compatibility, falls back to "auth-int" when it's the only option
+func (c *DigestChallenge) SelectQop() string {
+	if len(c.Qop) == 0 {
+		return ""
+	}
+	for _, option := range c.Qop {
+		if option == "auth" {
+			return "auth"
+		}
+	}
+	for _, option := range c.Qop {
+		if option == "auth-int" {
+			return "auth-int"
+		}
+	}
+	return ""
+}
+
+// baseAlgorithm returns the hash algorithm name without the -sess suffix
+func (c *DigestChallenge) baseAlgorithm() string {
+	algorithm := strings.ToUpper(c.Algorithm)
+	if algorithm == "" {
+		return "MD5"
+	}
+	return strings.TrimSuffix(algorithm, "-SESS")
+}
+
+// isSession reports whether the challenge uses a -sess algorithm variant
+func (c *DigestChallenge) isSession() bool {
+	return strings.HasSuffix(strings.ToUpper(c.Algorithm), "-SESS")
+}
+
+// encodeCredential returns the bytes of a username or password as they are hashed
+// With charset=UTF-8 that is UTF-8 (RFC 7616 section 4). Without it the encoding is
+// unspecified; legacy servers expect ISO-8859-1, so values that fit are hashed as that
+func (c *DigestChallenge) encodeCredential(value string) string {
+	if strings.EqualFold(c.Charset, "UTF-8") {
+		return value
+	}
+	latin1 := make([]byte, 0, len(value))
+	for _, r := range value {
+		if r > 0xff {
+			return value // Not representable in ISO-8859-1: UTF-8 is the only option
+		}
+		latin1 = append(latin1, byte(r))
+	}
+	return string(latin1)
+}
+
+// hash hashes input with the challenge algorithm and returns lowercase hex
+func (c *DigestChallenge) hash(input string) string {
+	newHash, ok := digestAlgorithms[c.baseAlgorithm()]
+	if !ok {
+		newHash = md5.New
+	}
+	h := newHash()
+	h.Write([]byte(input))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CalculateDigestAuth calculates Digest authorization header
+// body is the exact request entity, only used when qop=auth-int is negotiated
+func CalculateDigestAuth(req *ProxyRequest, challenge *DigestChallenge, body []byte) (string, error) {
+	return buildDigestAuthorization(req, challenge, body, 1, generateSecureNonce())
+}
+
+// buildDigestAuthorization computes the Authorization header for a given nonce count and cnonce
+func buildDigestAuthorization(req *ProxyRequest, challenge *DigestChallenge, body []byte, nonceCount uint32, cnonce string) (string, error) {
+	if !challenge.Supported() {
+		return "", fmt.Errorf("unsupported Digest algorithm: %s", challenge.Algorithm)
+	}
+
+	target, err := req.targetURL()
+	if err != nil {
+		return "", err
+	}
+	uri := digestURI(target)
+	qop := challenge.SelectQop()
+	if len(challenge.Qop) > 0 && qop == "" {
+		return "", fmt.Errorf("unsupported Digest qop options: %s", strings.Join(challenge.Qop, ","))
+	}
+	nc := fmt.Sprintf("%08x", nonceCount)
+
+	username := challenge.encodeCredential(req.Username)
+	ha1 := challenge.hash(fmt.Sprintf("%s:%s:%s", username, challenge.Realm, challenge.encodeCredential(req.Password)))
+	if challenge.isSession() {
+		ha1 = challenge.hash(fmt.Sprintf("%s:%s:%s", ha1, challenge.Nonce, cnonce))
+	}
+
+	a2 := fmt.Sprintf("%s:%s", req.Method, uri)
+	if qop == "auth-int" {
+		a2 = fmt.Sprintf("%s:%s", a2, challenge.hash(string(body)))
+	}
+	ha2 := challenge.hash(a2)
+
+	var response string
+	if qop == "" {
+		response = challenge.hash(fmt.Sprintf("%s:%s:%s", ha1, challenge.Nonce, ha2))
+	} else {
+		response = challenge.hash(fmt.Sprintf("%s:%s:%s:%s:%s:%s", ha1, challenge.Nonce, nc, cnonce, qop, ha2))
+	}
+
+	var auth strings.Builder
+	auth.WriteString("Digest ")
+
+	// RFC 7616 3.4.4: hash the username, or use username* when it can't be sent as a quoted string
+	switch {
+	case challenge.UserHash:
+		fmt.Fprintf(&auth, `username="%s"`, challenge.hash(fmt.Sprintf("%s:%s", username, challenge.Realm)))
+	case needsExtendedUsername(req.Username):
+		fmt.Fprintf(&auth, `username*=UTF-8''%s`, url.PathEscape(req.Username))
+	default:
+		fmt.Fprintf(&auth, `username="%s"`, req.Username)
+	}
+
+	fmt.Fprintf(&auth, `, realm="%s", nonce="%s", uri="%s", response="%s"`,
+		quoteEscape(challenge.Realm), quoteEscape(challenge.Nonce), uri, response)
+
+	if challenge.Opaque != "" {
+		fmt.Fprintf(&auth, `, opaque="%s"`, quoteEscape(challenge.Opaque))
+	}
+
+	if challenge.Algorithm != "" {
+		fmt.Fprintf(&auth, `, algorithm=%s`, challenge.Algorithm)
+	}
+
+	if qop != "" {
+		fmt.Fprintf(&auth, `, qop=%s, nc=%s, cnonce="%s"`, qop, nc, cnonce)
+	}
+
+	if challenge.UserHash {
+		auth.WriteString(", userhash=true")
+	}
+
+	return auth.String(), nil
+}
+
+// digestURI returns the request-target used in the Digest uri parameter
+func digestURI(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return rawURL
+	}
+	return parsed.RequestURI()
+}
+
+// needsExtendedUsername reports whether a username must use the username* form
+func needsExtendedUsername(username string) bool {
+	for _, r := range username {
+		if r > 0x7e || r < 0x20 || r == '"' || r == '\\' {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteEscape escapes a value for use inside a quoted-string
+func quoteEscape(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
+
+// parseAuthParams parses comma-separated auth-param pairs into a map with lowercase keys
+// Values may be tokens or quoted strings containing commas and escaped quotes
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params
+		}
+
+		eq := strings.IndexByte(s, '=')
+		if eq == -1 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s); i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+					b.WriteByte(s[i])
+					continue
+				}
+				if s[i] == '"' {
+					break
+				}
+				b.WriteByte(s[i])
+			}
+			value = b.String()
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end == -1 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+
+		params[key] = value
+	}
+}
+
+// splitChallenges splits a WWW-Authenticate value that may hold several challenges
+// e.g. `Digest realm="a", algorithm=SHA-256, Digest realm="a", algorithm=MD5, Basic realm="a"`
+func splitChallenges(header string) []string {
+	var challenges []string
+	start := 0
+	inQuotes := false
+
+	for i := 0; i < len(header); i++ {
+		switch header[i] {
+		case '\\':
+			if inQuotes {
+				i++
+			}
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if inQuotes {
+				continue
+			}
+			// A new challenge starts when the next item is "<scheme> <param>=" rather than "<param>="
+			rest := strings.TrimLeft(header[i+1:], " \t")
+			space := strings.IndexAny(rest, " \t")
+			eq := strings.IndexByte(rest, '=')
+			if space > 0 && (eq == -1 || space < eq) {
+				challenges = append(challenges, strings.TrimSpace(header[start:i]))
+				start = i + 1
+			}
+		}
+	}
+
+	return append(challenges, strings.TrimSpace(header[start:]))
+}
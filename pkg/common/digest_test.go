@@ -0,0 +1,291 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// RFC 7616 section 3.9.1 example
+const rfc7616Challenge = `Digest realm="http-auth@example.org", qop="auth, auth-int", ` +
+	`nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", ` +
+	`opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`
+
+func TestBuildDigestAuthorization(t *testing.T) {
+	tests := []struct {
+		name      string
+		req       ProxyRequest
+		challenge string
+		body      string // Request entity, hashed for qop=auth-int
+		cnonce    string
+		want      []string
+	}{
+		{
+			name:      "RFC 7616 MD5",
+			req:       ProxyRequest{URL: "http://www.example.org/dir/index.html", Method: "GET", Username: "Mufasa", Password: "Circle of Life"},
+			challenge: rfc7616Challenge + ", algorithm=MD5",
+			cnonce:    "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			want: []string{
+				`username="Mufasa"`,
+				`uri="/dir/index.html"`,
+				`response="8ca523f5e9506fed4657c9700eebdbec"`,
+				`qop=auth, nc=00000001, cnonce="f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"`,
+				`opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`,
+			},
+		},
+		{
+			name:      "RFC 7616 SHA-256",
+			req:       ProxyRequest{URL: "http://www.example.org/dir/index.html", Method: "GET", Username: "Mufasa", Password: "Circle of Life"},
+			challenge: rfc7616Challenge + ", algorithm=SHA-256",
+			cnonce:    "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			want: []string{
+				`username="Mufasa"`,
+				`response="753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"`,
+				`algorithm=SHA-256`,
+			},
+		},
+		{
+			// RFC 7616 section 3.9.2 example, with userhash
+			// Expected values are the corrected ones from RFC 7616 erratum 4897
+			name: "RFC 7616 SHA-512-256 userhash",
+			req:  ProxyRequest{URL: "http://api.example.org/doe.json", Method: "GET", Username: "Jäsøn Doe", Password: "Secret, or not?"},
+			challenge: `Digest realm="api@example.org", qop="auth", algorithm=SHA-512-256, ` +
+				`nonce="5TsQWLVdgBdmrQ0XsxbDODV+57QdFR34I9HAbC/RVvkK", ` +
+				`opaque="HRPCssKJSGjCrkzDg8OhwpzCiGPChXYjwrI2QmXDnsOS", charset=UTF-8, userhash=true`,
+			cnonce: "NTg6RKcb9boFIAS3KrFK9BGeh+iDa/sm6jUMp2wds69v",
+			want: []string{
+				`username="793263caabb707a56211940d90411ea4a575adeccb7e360aeb624ed06ece9b0b"`,
+				`uri="/doe.json"`,
+				`response="3798d4131c277846293534c3edc11bd8a5e4cdcbff78b05db9d95eeb1cec68a5"`,
+				`algorithm=SHA-512-256`,
+				`userhash=true`,
+			},
+		},
+		{
+			name:      "extended username without userhash",
+			req:       ProxyRequest{URL: "http://api.example.org/doe.json", Method: "GET", Username: "Jäsøn Doe", Password: "Secret, or not?"},
+			challenge: `Digest realm="api@example.org", qop="auth", algorithm=SHA-256, nonce="abc"`,
+			cnonce:    "xyz",
+			want:      []string{`username*=UTF-8''J%C3%A4s%C3%B8n%20Doe`},
+		},
+		{
+			name:      "MD5-sess",
+			req:       ProxyRequest{URL: "http://www.example.org/dir/index.html", Method: "GET", Username: "Mufasa", Password: "Circle of Life"},
+			challenge: rfc7616Challenge + ", algorithm=MD5-sess",
+			cnonce:    "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			want: []string{
+				`response="e783283f46242139c486a698fec7211d"`,
+				`algorithm=MD5-sess`,
+				`qop=auth, nc=00000001`,
+			},
+		},
+		{
+			name:      "SHA-256-sess",
+			req:       ProxyRequest{URL: "http://www.example.org/dir/index.html", Method: "GET", Username: "Mufasa", Password: "Circle of Life"},
+			challenge: rfc7616Challenge + ", algorithm=SHA-256-sess",
+			cnonce:    "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			want: []string{
+				`response="2fd51b3a77ad75bad6afad6003e818d767133c46d9e2749e7f5232ae1ea3efd7"`,
+				`algorithm=SHA-256-sess`,
+			},
+		},
+		{
+			name: "MD5 auth-int with body",
+			req:  ProxyRequest{URL: "http://www.example.org/dir/index.html", Method: "POST", Username: "Mufasa", Password: "Circle of Life"},
+			challenge: `Digest realm="http-auth@example.org", qop="auth-int", ` +
+				`nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", algorithm=MD5`,
+			body:   `{"apiVersion":"1.0","method":"getProperties"}`,
+			cnonce: "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			want: []string{
+				`response="10a76e8d676051b1efdf5b7f6217a958"`,
+				`qop=auth-int, nc=00000001`,
+			},
+		},
+		{
+			name: "SHA-256 auth-int with empty body",
+			req:  ProxyRequest{URL: "http://www.example.org/dir/index.html", Method: "GET", Username: "Mufasa", Password: "Circle of Life"},
+			challenge: `Digest realm="http-auth@example.org", qop="auth-int", ` +
+				`nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", algorithm=SHA-256`,
+			cnonce: "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			want:   []string{`response="8bdf6f15638e260831e905028de5450562816d093c9bfc5c13d3a46adcdde940"`},
+		},
+		{
+			name: "SHA-256-sess auth-int",
+			req:  ProxyRequest{URL: "http://www.example.org/dir/index.html", Method: "POST", Username: "Mufasa", Password: "Circle of Life"},
+			challenge: `Digest realm="http-auth@example.org", qop="auth-int", ` +
+				`nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", algorithm=SHA-256-sess`,
+			body:   `{"apiVersion":"1.0","method":"getProperties"}`,
+			cnonce: "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			want:   []string{`response="cd343adc8f4db5eee3f4d9f79b155a6ee678d6733f70ecb5e4bd127384ff53c6"`},
+		},
+		{
+			name:      "no charset: ISO-8859-1 password",
+			req:       ProxyRequest{URL: "http://www.example.org/dir/index.html", Method: "GET", Username: "root", Password: "Pässwörd"},
+			challenge: rfc7616Challenge + ", algorithm=MD5",
+			cnonce:    "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			want:      []string{`response="087f0243296cecccc7d756561aaf84c2"`},
+		},
+		{
+			name:      "charset=UTF-8: UTF-8 password",
+			req:       ProxyRequest{URL: "http://www.example.org/dir/index.html", Method: "GET", Username: "root", Password: "Pässwörd"},
+			challenge: rfc7616Challenge + ", algorithm=MD5, charset=UTF-8",
+			cnonce:    "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			want:      []string{`response="ad62fbae433ee98baa90fe95c0176a61"`},
+		},
+		{
+			name:      "no charset: password outside ISO-8859-1 stays UTF-8",
+			req:       ProxyRequest{URL: "http://www.example.org/dir/index.html", Method: "GET", Username: "root", Password: "Пароль"},
+			challenge: rfc7616Challenge + ", algorithm=MD5",
+			cnonce:    "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			want:      []string{`response="eb65dafc45012ccd834251365947124c"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge, err := ParseDigestChallenge(tt.challenge)
+			if err != nil {
+				t.Fatalf("ParseDigestChallenge: %v", err)
+			}
+			got, err := buildDigestAuthorization(&tt.req, challenge, []byte(tt.body), 1, tt.cnonce)
+			if err != nil {
+				t.Fatalf("buildDigestAuthorization: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("authorization %q missing %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitChallenges(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   []string
+	}{
+		{
+			name:   "single",
+			header: `Digest realm="a", nonce="n"`,
+			want:   []string{`Digest realm="a", nonce="n"`},
+		},
+		{
+			name:   "multiple",
+			header: `Digest realm="a", algorithm=SHA-256, Digest realm="a", algorithm=MD5, Basic realm="a"`,
+			want: []string{
+				`Digest realm="a", algorithm=SHA-256`,
+				`Digest realm="a", algorithm=MD5`,
+				`Basic realm="a"`,
+			},
+		},
+		{
+			name:   "quoted comma followed by scheme-like text",
+			header: `Digest realm="a, Basic b=c", nonce="n", Basic realm="x"`,
+			want: []string{
+				`Digest realm="a, Basic b=c", nonce="n"`,
+				`Basic realm="x"`,
+			},
+		},
+		{
+			name:   "escaped quote",
+			header: `Digest realm="say \"hi\", Basic x=y", nonce="n", Basic realm="x"`,
+			want: []string{
+				`Digest realm="say \"hi\", Basic x=y", nonce="n"`,
+				`Basic realm="x"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitChallenges(tt.header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitChallenges(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{
+			name:  "tokens and quoted strings",
+			input: `realm="a", Nonce=abc, qop="auth,auth-int"`,
+			want:  map[string]string{"realm": "a", "nonce": "abc", "qop": "auth,auth-int"},
+		},
+		{
+			name:  "quoted comma",
+			input: `realm="one, two", nonce="n"`,
+			want:  map[string]string{"realm": "one, two", "nonce": "n"},
+		},
+		{
+			name:  "escaped quotes and backslashes",
+			input: `realm="say \"hi\" \\ bye", opaque="o"`,
+			want:  map[string]string{"realm": `say "hi" \ bye`, "opaque": "o"},
+		},
+		{
+			name:  "spaces around equals",
+			input: ` realm = "a" ,stale = TRUE`,
+			want:  map[string]string{"realm": "a", "stale": "TRUE"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseAuthParams(tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAuthParams(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectDigestChallenge(t *testing.T) {
+	challenge, err := SelectDigestChallenge([]string{
+		`Digest realm="a", nonce="n", algorithm=MD5, Digest realm="a", nonce="n", algorithm=SHA-256`,
+		`Basic realm="a"`,
+	})
+	if err != nil {
+		t.Fatalf("SelectDigestChallenge: %v", err)
+	}
+	if challenge.Algorithm != "SHA-256" {
+		t.Errorf("algorithm = %s, want SHA-256", challenge.Algorithm)
+	}
+}
+
+func TestParseDigestChallengeUserHash(t *testing.T) {
+	for header, want := range map[string]bool{
+		`Digest realm="a", nonce="n", userhash=true`:   true,
+		`Digest realm="a", nonce="n", userhash="TRUE"`: true,
+		`Digest realm="a", nonce="n", userhash=false`:  false,
+		`Digest realm="a", nonce="n"`:                  false,
+	} {
+		challenge, err := ParseDigestChallenge(header)
+		if err != nil {
+			t.Fatalf("ParseDigestChallenge(%q): %v", header, err)
+		}
+		if challenge.UserHash != want {
+			t.Errorf("ParseDigestChallenge(%q).UserHash = %v, want %v", header, challenge.UserHash, want)
+		}
+	}
+}
+
+func TestParseDigestChallengeCharset(t *testing.T) {
+	for header, want := range map[string]string{
+		`Digest realm="a", nonce="n", charset=UTF-8`:   "UTF-8",
+		`Digest realm="a", nonce="n", charset="utf-8"`: "utf-8",
+		`Digest realm="a", nonce="n"`:                  "",
+	} {
+		challenge, err := ParseDigestChallenge(header)
+		if err != nil {
+			t.Fatalf("ParseDigestChallenge(%q): %v", header, err)
+		}
+		if challenge.Charset != want {
+			t.Errorf("ParseDigestChallenge(%q).Charset = %q, want %q", header, challenge.Charset, want)
+		}
+	}
+}
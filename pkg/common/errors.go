@@ -0,0 +1,153 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// ErrorCode classifies proxy failures so the extension can show precise
+// guidance without parsing error messages
+type ErrorCode string
+
+const (
+	ErrCodeDNSFailure        ErrorCode = "dns_failure"
+	ErrCodeConnectionRefused ErrorCode = "connection_refused"
+	ErrCodeTimeout           ErrorCode = "timeout"
+	ErrCodeTLSHandshake      ErrorCode = "tls_handshake"
+	ErrCodeCertMismatch      ErrorCode = "cert_mismatch"
+	ErrCodeAuthFailed        ErrorCode = "auth_failed"
+	ErrCodeCameraHTTPError   ErrorCode = "camera_http_error"
+	ErrCodeBadRequest        ErrorCode = "bad_request"
+	ErrCodeCanceled          ErrorCode = "canceled" // The caller went away before the camera answered
+	ErrCodeUnknown           ErrorCode = "unknown"
+)
+
+// statusClientClosedRequest reports a request the client canceled (nginx's 499);
+// nothing failed on the proxy or camera side, so it isn't a 5xx
+const statusClientClosedRequest = 499
+
+// ErrCertificateMismatch is returned by certificate verification when a
+// camera presents a different certificate than the pinned one
+var ErrCertificateMismatch = errors.New("certificate fingerprint mismatch")
+
+// ProxyError attaches an ErrorCode to an error
+type ProxyError struct {
+	Code ErrorCode
+	Err  error
+}
+
+// NewProxyError wraps err with code
+func NewProxyError(code ErrorCode, err error) error {
+	return &ProxyError{Code: code, Err: err}
+}
+
+func (e *ProxyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProxyError) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyError returns the ErrorCode for err
+// Explicit ProxyError codes win; otherwise the error chain is inspected by type
+func ClassifyError(err error) ErrorCode {
+	if err == nil {
+		return ""
+	}
+
+	var proxyErr *ProxyError
+	if errors.As(err, &proxyErr) {
+		return proxyErr.Code
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return ErrCodeCanceled
+	}
+
+	if errors.Is(err, ErrCertificateMismatch) {
+		return ErrCodeCertMismatch
+	}
+
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &hostnameErr) {
+		return ErrCodeCertMismatch
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrCodeDNSFailure
+	}
+
+	if IsConnectionRefusedError(err) {
+		return ErrCodeConnectionRefused
+	}
+
+	if isTLSError(err) {
+		return ErrCodeTLSHandshake
+	}
+
+	if IsTimeoutError(err) {
+		return ErrCodeTimeout
+	}
+
+	return ErrCodeUnknown
+}
+
+// IsTimeoutError checks if error is a timeout
+func IsTimeoutError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ETIMEDOUT) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// IsConnectionRefusedError checks if error is connection refused
+func IsConnectionRefusedError(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// isTLSError checks if error happened during the TLS handshake
+func isTLSError(err error) bool {
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &verifyErr) ||
+		errors.As(err, &unknownAuthorityErr) || errors.As(err, &invalidErr) {
+		return true
+	}
+
+	// Alerts sent by the camera surface as net.OpError{Op: "remote error"}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "remote error"
+}
+
+// HTTPStatus returns the status the proxy uses to report a failure with code
+func (c ErrorCode) HTTPStatus() int {
+	switch c {
+	case ErrCodeBadRequest:
+		return http.StatusBadRequest
+	case ErrCodeTimeout:
+		return http.StatusGatewayTimeout
+	case ErrCodeCanceled:
+		return statusClientClosedRequest
+	case ErrCodeUnknown, "":
+		return http.StatusInternalServerError
+	default:
+		// The camera could not be reached or authenticated
+		return http.StatusBadGateway
+	}
+}
@@ -0,0 +1,95 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		want       ErrorCode
+		wantStatus int
+	}{
+		{
+			name: "nil",
+			err:  nil,
+			want: "",
+			// No failure: callers don't ask for a status
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "explicit code wins",
+			err:        NewProxyError(ErrCodeBadRequest, context.Canceled),
+			want:       ErrCodeBadRequest,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "canceled",
+			err:        fmt.Errorf("request execution failed: %w", context.Canceled),
+			want:       ErrCodeCanceled,
+			wantStatus: statusClientClosedRequest,
+		},
+		{
+			name:       "deadline",
+			err:        fmt.Errorf("request execution failed: %w", context.DeadlineExceeded),
+			want:       ErrCodeTimeout,
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:       "dial timeout",
+			err:        &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded},
+			want:       ErrCodeTimeout,
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:       "connection refused",
+			err:        &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+			want:       ErrCodeConnectionRefused,
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "dns",
+			err:        &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "camera.invalid"}},
+			want:       ErrCodeDNSFailure,
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "certificate mismatch",
+			err:        fmt.Errorf("handshake: %w", ErrCertificateMismatch),
+			want:       ErrCodeCertMismatch,
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "remote TLS alert",
+			err:        &net.OpError{Op: "remote error", Err: errors.New("tls: handshake failure")},
+			want:       ErrCodeTLSHandshake,
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "anything else",
+			err:        errors.New("failed to decode proxy response"),
+			want:       ErrCodeUnknown,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ClassifyError(tt.err)
+			if got != tt.want {
+				t.Errorf("ClassifyError(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+			if status := got.HTTPStatus(); status != tt.wantStatus {
+				t.Errorf("%q.HTTPStatus() = %d, want %d", got, status, tt.wantStatus)
+			}
+		})
+	}
+}
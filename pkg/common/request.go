@@ -0,0 +1,88 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultProbeTimeout is the unauthenticated probe timeout (same as Electron)
+const DefaultProbeTimeout = 3 * time.Second
+
+// DefaultRequestTimeout bounds each camera round trip when the request sets no TimeoutMs
+const DefaultRequestTimeout = 30 * time.Second
+
+// targetURL returns req.URL with req.Query merged into its query string
+func (req *ProxyRequest) targetURL() (string, error) {
+	if len(req.Query) == 0 {
+		return req.URL, nil
+	}
+
+	parsed, err := url.Parse(req.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+
+	query := parsed.Query()
+	for key, value := range req.Query {
+		query.Set(key, value)
+	}
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String(), nil
+}
+
+// newCameraRequest builds an HTTP request for req with the given encoded body, bound to ctx
+// Sets Content-Type/Content-Length when there is a body; custom headers are applied separately
+func newCameraRequest(ctx context.Context, req *ProxyRequest, bodyBytes []byte, contentType string) (*http.Request, error) {
+	target, err := req.targetURL()
+	if err != nil {
+		return nil, err
+	}
+
+	var bodyReader io.Reader
+	if bodyBytes != nil {
+		bodyReader = bytes.NewReader(bodyBytes)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, target, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+
+	if bodyBytes != nil {
+		httpReq.Header.Set("Content-Type", contentType)
+		httpReq.Header.Set("Content-Length", fmt.Sprintf("%d", len(bodyBytes)))
+	}
+
+	return httpReq, nil
+}
+
+// applyCustomHeaders copies req.Headers onto httpReq
+// Authorization is owned by the auth path and is never overridden
+func applyCustomHeaders(httpReq *http.Request, req *ProxyRequest) {
+	for key, value := range req.Headers {
+		if strings.EqualFold(key, "Authorization") {
+			continue
+		}
+		httpReq.Header.Set(key, value)
+	}
+}
+
+// clientWithTimeout returns client, or a copy sharing its transport when timeoutMs overrides it
+func clientWithTimeout(client *http.Client, timeoutMs int) *http.Client {
+	if timeoutMs <= 0 {
+		return client
+	}
+	return &http.Client{
+		Transport:     client.Transport,
+		CheckRedirect: client.CheckRedirect,
+		Jar:           client.Jar,
+		Timeout:       time.Duration(timeoutMs) * time.Millisecond,
+	}
+}
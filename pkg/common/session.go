@@ -0,0 +1,186 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// DigestCache remembers Digest challenges per camera so follow-up requests can
+// authenticate preemptively with an incremented nonce count instead of
+// collecting a fresh challenge every time
+// A nil *DigestCache is valid and caches nothing
+type DigestCache struct {
+	mu       sync.Mutex
+	realms   map[string]string // host+credentials -> last realm seen
+	sessions map[digestCacheKey]*digestSession
+}
+
+// digestCacheKey identifies one protection space for one set of credentials
+type digestCacheKey struct {
+	host        string
+	realm       string
+	credentials string // SHA256 of username and password, never the plaintext
+}
+
+// digestSession is a cached challenge plus the last nonce count used with it
+type digestSession struct {
+	challenge  *DigestChallenge
+	nonceCount uint32
+}
+
+// NewDigestCache creates an empty Digest session cache
+func NewDigestCache() *DigestCache {
+	return &DigestCache{
+		realms:   make(map[string]string),
+		sessions: make(map[digestCacheKey]*digestSession),
+	}
+}
+
+// Authorization returns an Authorization header for req built from the cached
+// challenge for its host and credentials, using the next nonce count
+func (dc *DigestCache) Authorization(req *ProxyRequest, body []byte) (string, bool) {
+	if dc == nil {
+		return "", false
+	}
+
+	host, credentials := digestCacheIdentity(req)
+
+	dc.mu.Lock()
+	realm, ok := dc.realms[host+"|"+credentials]
+	if !ok {
+		dc.mu.Unlock()
+		return "", false
+	}
+	session, ok := dc.sessions[digestCacheKey{host: host, realm: realm, credentials: credentials}]
+	if !ok {
+		dc.mu.Unlock()
+		return "", false
+	}
+	session.nonceCount++
+	challenge := session.challenge
+	nonceCount := session.nonceCount
+	dc.mu.Unlock()
+
+	auth, err := buildDigestAuthorization(req, challenge, body, nonceCount, generateSecureNonce())
+	if err != nil {
+		return "", false
+	}
+	return auth, true
+}
+
+// Store caches challenge for req's host and credentials and resets the nonce count
+func (dc *DigestCache) Store(req *ProxyRequest, challenge *DigestChallenge) {
+	if dc == nil {
+		return
+	}
+
+	host, credentials := digestCacheIdentity(req)
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.realms[host+"|"+credentials] = challenge.Realm
+	dc.sessions[digestCacheKey{host: host, realm: challenge.Realm, credentials: credentials}] = &digestSession{
+		challenge: challenge,
+	}
+}
+
+// Invalidate drops the cached session for req's host and credentials
+func (dc *DigestCache) Invalidate(req *ProxyRequest) {
+	if dc == nil {
+		return
+	}
+
+	host, credentials := digestCacheIdentity(req)
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if realm, ok := dc.realms[host+"|"+credentials]; ok {
+		delete(dc.sessions, digestCacheKey{host: host, realm: realm, credentials: credentials})
+		delete(dc.realms, host+"|"+credentials)
+	}
+}
+
+// digestCacheIdentity returns the host and hashed credentials used as cache keys
+func digestCacheIdentity(req *ProxyRequest) (string, string) {
+	host := req.URL
+	if parsed, err := url.Parse(req.URL); err == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+	sum := sha256.Sum256([]byte(req.Username + "\x00" + req.Password))
+	return host, hex.EncodeToString(sum[:])
+}
+
+// DoDigestRequest sends the request built by newRequest, answering a Digest
+// challenge if needed. A cached session is used preemptively; the camera is
+// only re-challenged when it answers 401 (including stale=true)
+// newRequest is called once per attempt and must return a request with a fresh body
+// bound to ctx; no retry is attempted once ctx is done
+func DoDigestRequest(ctx context.Context, client *http.Client, cache *DigestCache, req *ProxyRequest, body []byte, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	send := func(authorization string) (*http.Response, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		httpReq, err := newRequest(ctx)
+		if err != nil {
+			return nil, NewProxyError(ErrCodeBadRequest, fmt.Errorf("failed to create request: %w", err))
+		}
+		if authorization != "" {
+			httpReq.Header.Set("Authorization", authorization)
+		}
+		return client.Do(httpReq)
+	}
+
+	// Try the cached session first (no challenge round trip)
+	authorization, cached := cache.Authorization(req, body)
+
+	httpResp, err := send(authorization)
+	if err != nil {
+		if cached {
+			return nil, fmt.Errorf("authenticated request failed: %w", err)
+		}
+		return nil, fmt.Errorf("initial request failed: %w", err)
+	}
+
+	if httpResp.StatusCode != 401 {
+		return httpResp, nil
+	}
+
+	// 401: either no session yet, the nonce went stale, or the cached one was rejected
+	challenge, err := SelectDigestChallenge(httpResp.Header.Values("WWW-Authenticate"))
+	if err != nil {
+		cache.Invalidate(req)
+		if cached {
+			// Cached credentials rejected without a usable challenge - let the caller see the 401
+			return httpResp, nil
+		}
+		httpResp.Body.Close()
+		return nil, NewProxyError(ErrCodeAuthFailed, fmt.Errorf("failed to parse Digest challenge: %w", err))
+	}
+	httpResp.Body.Close()
+
+	cache.Store(req, challenge)
+	authorization, ok := cache.Authorization(req, body)
+	if !ok {
+		authorization, err = CalculateDigestAuth(req, challenge, body)
+		if err != nil {
+			return nil, NewProxyError(ErrCodeAuthFailed, fmt.Errorf("failed to calculate Digest response: %w", err))
+		}
+	}
+
+	httpResp, err = send(authorization)
+	if err != nil {
+		return nil, fmt.Errorf("authenticated request failed: %w", err)
+	}
+
+	if httpResp.StatusCode == 401 {
+		// Fresh challenge still rejected - credentials are wrong, don't reuse the session
+		cache.Invalidate(req)
+	}
+
+	return httpResp, nil
+}
@@ -0,0 +1,327 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const sessionTestChallenge = `Digest realm="AXIS_ACCC8E000000", nonce="abc123", qop="auth", algorithm=MD5`
+
+// authorizationParams parses the auth-params of a Digest Authorization header
+func authorizationParams(t *testing.T, header string) map[string]string {
+	t.Helper()
+	if !strings.HasPrefix(header, "Digest ") {
+		t.Fatalf("Authorization = %q, want a Digest header", header)
+	}
+	return parseAuthParams(header[len("Digest "):])
+}
+
+func TestDigestCacheKeying(t *testing.T) {
+	stored := ProxyRequest{URL: "http://192.168.1.10/axis-cgi/basicdeviceid.cgi", Username: "root", Password: "pass"}
+
+	tests := []struct {
+		name   string
+		lookup ProxyRequest
+		want   bool
+	}{
+		{
+			name:   "same request",
+			lookup: stored,
+			want:   true,
+		},
+		{
+			name:   "other path on the same host",
+			lookup: ProxyRequest{URL: "http://192.168.1.10/axis-cgi/param.cgi?action=list", Username: "root", Password: "pass"},
+			want:   true,
+		},
+		{
+			name:   "other port",
+			lookup: ProxyRequest{URL: "http://192.168.1.10:8080/axis-cgi/basicdeviceid.cgi", Username: "root", Password: "pass"},
+			want:   false,
+		},
+		{
+			name:   "other host",
+			lookup: ProxyRequest{URL: "http://192.168.1.11/axis-cgi/basicdeviceid.cgi", Username: "root", Password: "pass"},
+			want:   false,
+		},
+		{
+			name:   "other username",
+			lookup: ProxyRequest{URL: "http://192.168.1.10/axis-cgi/basicdeviceid.cgi", Username: "operator", Password: "pass"},
+			want:   false,
+		},
+		{
+			name:   "other password",
+			lookup: ProxyRequest{URL: "http://192.168.1.10/axis-cgi/basicdeviceid.cgi", Username: "root", Password: "wrong"},
+			want:   false,
+		},
+		{
+			// The separator keeps "ro"+"otpass" from colliding with "root"+"pass"
+			name:   "credentials shifted across the separator",
+			lookup: ProxyRequest{URL: "http://192.168.1.10/axis-cgi/basicdeviceid.cgi", Username: "ro", Password: "otpass"},
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge, err := ParseDigestChallenge(sessionTestChallenge)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cache := NewDigestCache()
+			cache.Store(&stored, challenge)
+
+			if _, ok := cache.Authorization(&tt.lookup, nil); ok != tt.want {
+				t.Errorf("Authorization() cached = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigestCacheNonceCount(t *testing.T) {
+	req := &ProxyRequest{URL: "http://192.168.1.10/axis-cgi/basicdeviceid.cgi", Method: "GET", Username: "root", Password: "pass"}
+	challenge, err := ParseDigestChallenge(sessionTestChallenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each step runs against the same cache, in order
+	steps := []struct {
+		name   string
+		action func(cache *DigestCache)
+		wantNC string // "" means no cached session
+	}{
+		{name: "empty cache", wantNC: ""},
+		{name: "first use after Store", action: func(cache *DigestCache) { cache.Store(req, challenge) }, wantNC: "00000001"},
+		{name: "second use", wantNC: "00000002"},
+		{name: "third use", wantNC: "00000003"},
+		{name: "new challenge resets the count", action: func(cache *DigestCache) { cache.Store(req, challenge) }, wantNC: "00000001"},
+		{name: "invalidated", action: func(cache *DigestCache) { cache.Invalidate(req) }, wantNC: ""},
+	}
+
+	cache := NewDigestCache()
+	for _, step := range steps {
+		if step.action != nil {
+			step.action(cache)
+		}
+		auth, ok := cache.Authorization(req, nil)
+		if step.wantNC == "" {
+			if ok {
+				t.Errorf("%s: Authorization() = %q, want no cached session", step.name, auth)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s: Authorization() found no cached session", step.name)
+			continue
+		}
+		if nc := authorizationParams(t, auth)["nc"]; nc != step.wantNC {
+			t.Errorf("%s: nc = %s, want %s", step.name, nc, step.wantNC)
+		}
+	}
+}
+
+func TestDigestCacheNil(t *testing.T) {
+	var cache *DigestCache
+	req := &ProxyRequest{URL: "http://192.168.1.10/", Username: "root", Password: "pass"}
+	challenge, err := ParseDigestChallenge(sessionTestChallenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Store(req, challenge)
+	cache.Invalidate(req)
+	if _, ok := cache.Authorization(req, nil); ok {
+		t.Error("nil cache returned a cached session")
+	}
+}
+
+// digestCamera is a minimal Digest-protected camera endpoint
+// It verifies every response and keeps the previous nonce around to answer stale=true
+type digestCamera struct {
+	mu        sync.Mutex
+	password  string
+	nonce     string
+	oldNonces map[string]bool
+	seen      []string // nc of each request, "" for requests without Authorization
+}
+
+func newDigestCamera(password string) *digestCamera {
+	return &digestCamera{password: password, nonce: "nonce-1", oldNonces: make(map[string]bool)}
+}
+
+// rotateNonce expires the current nonce, as a camera does after its nonce lifetime
+func (c *digestCamera) rotateNonce() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.oldNonces[c.nonce] = true
+	c.nonce = "nonce-" + strconv.Itoa(len(c.oldNonces)+1)
+}
+
+func (c *digestCamera) challenge(stale bool) string {
+	header := `Digest realm="AXIS_ACCC8E000000", nonce="` + c.nonce + `", qop="auth", algorithm=MD5`
+	if stale {
+		header += ", stale=true"
+	}
+	return header
+}
+
+func (c *digestCamera) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Digest ") {
+		c.seen = append(c.seen, "")
+		w.Header().Set("WWW-Authenticate", c.challenge(false))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	params := parseAuthParams(header[len("Digest "):])
+	c.seen = append(c.seen, params["nc"])
+
+	if params["nonce"] != c.nonce {
+		w.Header().Set("WWW-Authenticate", c.challenge(c.oldNonces[params["nonce"]]))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if !c.validResponse(r, params) {
+		w.Header().Set("WWW-Authenticate", c.challenge(false))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// validResponse recomputes the Digest response with the camera's password
+func (c *digestCamera) validResponse(r *http.Request, params map[string]string) bool {
+	nc, err := strconv.ParseUint(params["nc"], 16, 32)
+	if err != nil {
+		return false
+	}
+	challenge, err := ParseDigestChallenge(c.challenge(false))
+	if err != nil {
+		return false
+	}
+	expected := &ProxyRequest{URL: "http://" + r.Host + r.URL.RequestURI(), Method: r.Method, Username: params["username"], Password: c.password}
+	want, err := buildDigestAuthorization(expected, challenge, nil, uint32(nc), params["cnonce"])
+	if err != nil {
+		return false
+	}
+	return parseAuthParams(want[len("Digest "):])["response"] == params["response"]
+}
+
+// takeSeen returns and clears the nonce counts seen so far
+func (c *digestCamera) takeSeen() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := c.seen
+	c.seen = nil
+	return seen
+}
+
+func TestDoDigestRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		// prime runs one successful request first, so the cache holds a session
+		prime bool
+		// before runs against the camera after priming
+		before     func(camera *digestCamera)
+		password   string
+		wantStatus int
+		wantSeen   []string
+		wantCached bool
+	}{
+		{
+			name:       "challenge then answer",
+			password:   "pass",
+			wantStatus: http.StatusOK,
+			wantSeen:   []string{"", "00000001"},
+			wantCached: true,
+		},
+		{
+			name:       "cached session is used preemptively",
+			prime:      true,
+			password:   "pass",
+			wantStatus: http.StatusOK,
+			wantSeen:   []string{"00000002"},
+			wantCached: true,
+		},
+		{
+			name:       "stale nonce is replaced and the count restarts",
+			prime:      true,
+			before:     func(camera *digestCamera) { camera.rotateNonce() },
+			password:   "pass",
+			wantStatus: http.StatusOK,
+			wantSeen:   []string{"00000002", "00000001"},
+			wantCached: true,
+		},
+		{
+			name:       "wrong password",
+			password:   "wrong",
+			wantStatus: http.StatusUnauthorized,
+			wantSeen:   []string{"", "00000001"},
+			wantCached: false,
+		},
+		{
+			name:       "password changed on the camera invalidates the session",
+			prime:      true,
+			before:     func(camera *digestCamera) { camera.password = "changed" },
+			password:   "pass",
+			wantStatus: http.StatusUnauthorized,
+			wantSeen:   []string{"00000002", "00000001"},
+			wantCached: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			camera := newDigestCamera("pass")
+			server := httptest.NewServer(camera)
+			defer server.Close()
+
+			cache := NewDigestCache()
+			req := &ProxyRequest{URL: server.URL + "/axis-cgi/basicdeviceid.cgi", Method: "GET", Username: "root", Password: tt.password}
+			do := func() *http.Response {
+				t.Helper()
+				resp, err := DoDigestRequest(context.Background(), server.Client(), cache, req, nil, func(ctx context.Context) (*http.Request, error) {
+					return http.NewRequestWithContext(ctx, req.Method, req.URL, nil)
+				})
+				if err != nil {
+					t.Fatalf("DoDigestRequest() error: %v", err)
+				}
+				resp.Body.Close()
+				return resp
+			}
+
+			if tt.prime {
+				if resp := do(); resp.StatusCode != http.StatusOK {
+					t.Fatalf("priming request status = %d, want 200", resp.StatusCode)
+				}
+				camera.takeSeen()
+			}
+			if tt.before != nil {
+				tt.before(camera)
+			}
+
+			resp := do()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if seen := camera.takeSeen(); strings.Join(seen, ",") != strings.Join(tt.wantSeen, ",") {
+				t.Errorf("nonce counts sent = %q, want %q", seen, tt.wantSeen)
+			}
+			if _, cached := cache.Authorization(req, nil); cached != tt.wantCached {
+				t.Errorf("session cached = %v, want %v", cached, tt.wantCached)
+			}
+		})
+	}
+}
@@ -0,0 +1,495 @@
+package common
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	vaultFileName    = "credentials.vault"
+	vaultKeyFileName = "credentials.key"
+	vaultVersion     = 1
+)
+
+// CredentialSet is a camera username/password pair stored in the vault
+type CredentialSet struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Username     string    `json:"username"`
+	Password     string    `json:"password"`
+	AllowedHosts []string  `json:"allowedHosts,omitempty"` // Camera IPs, CIDR subnets and hostnames it may be sent to
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// CredentialSummary is the redacted form of a CredentialSet returned to the browser
+type CredentialSummary struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Username     string    `json:"username"` // Sanitized, e.g. "r**t"
+	AllowedHosts []string  `json:"allowedHosts"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// vaultFile is the on-disk format: AES-256-GCM ciphertext of the JSON credential list
+type vaultFile struct {
+	Version    int    `json:"version"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// CredentialVault stores camera credentials encrypted at rest next to connector-config.json
+// so the browser can refer to them by ID instead of sending passwords
+// The key lives in the OS key store (see vaultkey.go), not beside the vault
+type CredentialVault struct {
+	mu          sync.RWMutex
+	credentials map[string]*CredentialSet
+	filePath    string
+	keyPath     string // Plain key file of older versions, migrated and removed on open
+	key         []byte
+	unavailable error // Set when no key could be loaded; every operation fails with it
+}
+
+// NewCredentialVault opens (or creates) the credential vault in ~/.config/anava
+// Without a usable key store the vault is returned unavailable rather than failing,
+// so the rest of the connector keeps working; see Unavailable
+func NewCredentialVault() (*CredentialVault, error) {
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+	return openCredentialVault(dir)
+}
+
+// openCredentialVault opens the credential vault in dir
+func openCredentialVault(dir string) (*CredentialVault, error) {
+	vault := &CredentialVault{
+		credentials: make(map[string]*CredentialSet),
+		filePath:    filepath.Join(dir, vaultFileName),
+		keyPath:     filepath.Join(dir, vaultKeyFileName),
+	}
+
+	legacyKey, err := readLegacyVaultKey(vault.keyPath)
+	if err != nil {
+		return nil, err
+	}
+	vault.key, err = loadVaultKey(dir, legacyKey)
+	if err != nil {
+		vault.unavailable = fmt.Errorf("credential vault unavailable: %w", err)
+		return vault, nil
+	}
+
+	if legacyKey == nil {
+		if err := vault.load(); err != nil {
+			return nil, err
+		}
+		return vault, nil
+	}
+
+	// Migrate from the plain key file: re-encrypt if the key changed, then delete the file
+	key := vault.key
+	vault.key = legacyKey
+	if err := vault.load(); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(key, legacyKey) {
+		vault.key = key
+		vault.mu.Lock()
+		err := vault.save()
+		vault.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := os.Remove(vault.keyPath); err != nil {
+		return nil, fmt.Errorf("failed to remove old credential vault key file: %w", err)
+	}
+	vault.key = key
+
+	return vault, nil
+}
+
+// Unavailable returns why the vault can't be used (no key store or passphrase), or nil
+func (cv *CredentialVault) Unavailable() error {
+	return cv.unavailable
+}
+
+// load decrypts the vault file into memory
+func (cv *CredentialVault) load() error {
+	data, err := os.ReadFile(cv.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // Empty vault
+		}
+		return fmt.Errorf("failed to read credential vault: %w", err)
+	}
+
+	var file vaultFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse credential vault: %w", err)
+	}
+
+	plaintext, err := decryptVault(cv.key, &file)
+	if err != nil {
+		return err
+	}
+
+	var credentials []*CredentialSet
+	if err := json.Unmarshal(plaintext, &credentials); err != nil {
+		return fmt.Errorf("failed to parse credential vault contents: %w", err)
+	}
+
+	cv.mu.Lock()
+	for _, cred := range credentials {
+		cv.credentials[cred.ID] = cred
+	}
+	cv.mu.Unlock()
+
+	return nil
+}
+
+// save encrypts and writes the vault to disk (caller holds cv.mu)
+func (cv *CredentialVault) save() error {
+	credentials := make([]*CredentialSet, 0, len(cv.credentials))
+	for _, cred := range cv.credentials {
+		credentials = append(credentials, cred)
+	}
+
+	plaintext, err := json.Marshal(credentials)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+
+	block, err := aes.NewCipher(cv.key)
+	if err != nil {
+		return fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	data, err := json.MarshalIndent(vaultFile{
+		Version:    vaultVersion,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, nil)),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal credential vault: %w", err)
+	}
+
+	// Write to a temp file first so a crash never leaves a truncated vault
+	tmpPath := cv.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write credential vault: %w", err)
+	}
+	if err := os.Rename(tmpPath, cv.filePath); err != nil {
+		return fmt.Errorf("failed to write credential vault: %w", err)
+	}
+
+	return nil
+}
+
+// decryptVault decrypts a vault file with key
+func decryptVault(key []byte, file *vaultFile) ([]byte, error) {
+	if file.Version != vaultVersion {
+		return nil, fmt.Errorf("unsupported credential vault version: %d", file.Version)
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(file.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential vault nonce: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(file.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential vault ciphertext: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid credential vault nonce length")
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credential vault (wrong key?): %w", err)
+	}
+	return plaintext, nil
+}
+
+// Add stores a new credential set and returns its redacted summary
+// allowedHosts must name at least one camera IP, CIDR subnet or hostname
+func (cv *CredentialVault) Add(name, username, password string, allowedHosts []string) (CredentialSummary, error) {
+	if cv.unavailable != nil {
+		return CredentialSummary{}, cv.unavailable
+	}
+	if username == "" {
+		return CredentialSummary{}, fmt.Errorf("username is required")
+	}
+	allowedHosts, err := normalizeAllowedHosts(allowedHosts)
+	if err != nil {
+		return CredentialSummary{}, err
+	}
+	if len(allowedHosts) == 0 {
+		return CredentialSummary{}, fmt.Errorf("allowedHosts is required")
+	}
+
+	id, err := generateCredentialID()
+	if err != nil {
+		return CredentialSummary{}, err
+	}
+
+	now := time.Now().UTC()
+	cred := &CredentialSet{
+		ID:           id,
+		Name:         name,
+		Username:     username,
+		Password:     password,
+		AllowedHosts: allowedHosts,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	cv.credentials[id] = cred
+	if err := cv.save(); err != nil {
+		delete(cv.credentials, id)
+		return CredentialSummary{}, err
+	}
+
+	return cred.summary(), nil
+}
+
+// List returns redacted summaries of all stored credentials, oldest first
+func (cv *CredentialVault) List() []CredentialSummary {
+	cv.mu.RLock()
+	defer cv.mu.RUnlock()
+
+	summaries := make([]CredentialSummary, 0, len(cv.credentials))
+	for _, cred := range cv.credentials {
+		summaries = append(summaries, cred.summary())
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].CreatedAt.Before(summaries[j].CreatedAt)
+	})
+	return summaries
+}
+
+// Get returns a copy of the credential set with the given ID
+func (cv *CredentialVault) Get(id string) (CredentialSet, bool) {
+	cv.mu.RLock()
+	defer cv.mu.RUnlock()
+
+	cred, ok := cv.credentials[id]
+	if !ok {
+		return CredentialSet{}, false
+	}
+	return *cred, true
+}
+
+// Rotate replaces the password (and optionally username and allowed hosts) of a stored credential set
+func (cv *CredentialVault) Rotate(id, username, password string, allowedHosts []string) (CredentialSummary, error) {
+	if cv.unavailable != nil {
+		return CredentialSummary{}, cv.unavailable
+	}
+	if password == "" {
+		return CredentialSummary{}, fmt.Errorf("password is required")
+	}
+	allowedHosts, err := normalizeAllowedHosts(allowedHosts)
+	if err != nil {
+		return CredentialSummary{}, err
+	}
+
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	cred, ok := cv.credentials[id]
+	if !ok {
+		return CredentialSummary{}, fmt.Errorf("credential not found: %s", id)
+	}
+
+	previous := *cred
+	if username != "" {
+		cred.Username = username
+	}
+	if len(allowedHosts) > 0 {
+		cred.AllowedHosts = allowedHosts
+	}
+	cred.Password = password
+	cred.UpdatedAt = time.Now().UTC()
+
+	if err := cv.save(); err != nil {
+		*cred = previous
+		return CredentialSummary{}, err
+	}
+
+	return cred.summary(), nil
+}
+
+// Delete removes a stored credential set
+func (cv *CredentialVault) Delete(id string) error {
+	if cv.unavailable != nil {
+		return cv.unavailable
+	}
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	cred, ok := cv.credentials[id]
+	if !ok {
+		return fmt.Errorf("credential not found: %s", id)
+	}
+
+	delete(cv.credentials, id)
+	if err := cv.save(); err != nil {
+		cv.credentials[id] = cred
+		return err
+	}
+
+	return nil
+}
+
+// Resolve fills req.Username and req.Password from the vault when req.CredentialID is set
+func (cv *CredentialVault) Resolve(req *ProxyRequest) error {
+	return cv.ResolveFor(req.CredentialID, req.URL, &req.Username, &req.Password)
+}
+
+// ResolveFor fills username and password from the vault when credentialID is set
+// and the credential may be sent to targetURL
+// Used for payloads that aren't a ProxyRequest (uploads, the standalone proxy server)
+func (cv *CredentialVault) ResolveFor(credentialID, targetURL string, username, password *string) error {
+	if credentialID == "" {
+		return nil
+	}
+	if cv.unavailable != nil {
+		return cv.unavailable
+	}
+
+	cred, ok := cv.Get(credentialID)
+	if !ok {
+		return fmt.Errorf("credential not found: %s", credentialID)
+	}
+	if err := cred.checkURL(targetURL); err != nil {
+		return err
+	}
+
+	*username = cred.Username
+	*password = cred.Password
+	return nil
+}
+
+// summary returns the redacted form of cred
+func (cred *CredentialSet) summary() CredentialSummary {
+	return CredentialSummary{
+		ID:           cred.ID,
+		Name:         cred.Name,
+		Username:     SanitizeCredential(cred.Username),
+		AllowedHosts: cred.AllowedHosts,
+		CreatedAt:    cred.CreatedAt,
+		UpdatedAt:    cred.UpdatedAt,
+	}
+}
+
+// checkURL returns an error unless the host of rawURL is one the credential may be sent to
+func (cred *CredentialSet) checkURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return fmt.Errorf("invalid camera URL: %s", rawURL)
+	}
+	return cred.checkHost(parsed.Hostname())
+}
+
+// checkHost returns an error unless the credential may be sent to host
+func (cred *CredentialSet) checkHost(host string) error {
+	if len(cred.AllowedHosts) == 0 {
+		// Stored before credentials were tied to hosts
+		return fmt.Errorf("credential %s has no allowed hosts; set allowedHosts with /credentials/rotate", cred.ID)
+	}
+	if !cred.AllowsHost(host) {
+		return fmt.Errorf("credential %s is not allowed for host %s", cred.ID, host)
+	}
+	return nil
+}
+
+// AllowsHost reports whether the credential may be sent to host (an IP or hostname)
+// Hostnames are compared literally, never resolved, so DNS can't widen the list
+func (cred *CredentialSet) AllowsHost(host string) bool {
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	ip := net.ParseIP(host)
+
+	for _, allowed := range cred.AllowedHosts {
+		if _, subnet, err := net.ParseCIDR(allowed); err == nil {
+			if ip != nil && subnet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if allowedIP := net.ParseIP(allowed); allowedIP != nil {
+			if ip != nil && allowedIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if allowed == host {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeAllowedHosts validates and lower-cases allowed host entries
+func normalizeAllowedHosts(entries []string) ([]string, error) {
+	var hosts []string
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return nil, fmt.Errorf("invalid allowed subnet: %s", entry)
+			}
+		} else if net.ParseIP(entry) == nil && strings.ContainsAny(entry, ":*") {
+			return nil, fmt.Errorf("invalid allowed host: %s", entry)
+		}
+		hosts = append(hosts, entry)
+	}
+	return hosts, nil
+}
+
+// generateCredentialID returns a random credential ID like "cred_1a2b3c4d5e6f7a8b"
+func generateCredentialID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate credential ID: %w", err)
+	}
+	return "cred_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,382 @@
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// memoryKeyStore is an in-memory vaultKeyStore
+type memoryKeyStore struct {
+	key []byte
+}
+
+func (ks *memoryKeyStore) Load() ([]byte, error) {
+	if ks.key == nil {
+		return nil, errVaultKeyNotFound
+	}
+	return ks.key, nil
+}
+
+func (ks *memoryKeyStore) Store(key []byte) error {
+	ks.key = append([]byte(nil), key...)
+	return nil
+}
+
+func (ks *memoryKeyStore) String() string {
+	return "memory"
+}
+
+// useMemoryKeyStore replaces the platform key store for the rest of the test
+func useMemoryKeyStore(t *testing.T) *memoryKeyStore {
+	t.Helper()
+	store := &memoryKeyStore{}
+	original := openVaultKeyStore
+	openVaultKeyStore = func(string) (vaultKeyStore, error) { return store, nil }
+	t.Cleanup(func() { openVaultKeyStore = original })
+	t.Setenv(vaultPassphraseEnv, "")
+	return store
+}
+
+// openTestVault opens the vault in dir, failing the test on error
+func openTestVault(t *testing.T, dir string) *CredentialVault {
+	t.Helper()
+	vault, err := openCredentialVault(dir)
+	if err != nil {
+		t.Fatalf("openCredentialVault: %v", err)
+	}
+	if err := vault.Unavailable(); err != nil {
+		t.Fatalf("vault unavailable: %v", err)
+	}
+	return vault
+}
+
+func TestCredentialVaultRoundTrip(t *testing.T) {
+	store := useMemoryKeyStore(t)
+	dir := t.TempDir()
+
+	vault := openTestVault(t, dir)
+	summary, err := vault.Add("lobby", "root", "s3cret", []string{"192.168.1.10"})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if summary.Username == "root" {
+		t.Errorf("summary username not redacted: %q", summary.Username)
+	}
+
+	// Neither the password nor the key may appear in the config directory
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		if bytes.Contains(data, []byte("s3cret")) || bytes.Contains(data, []byte(hex.EncodeToString(store.key))) {
+			t.Errorf("%s contains the password or the vault key", filepath.Base(file))
+		}
+	}
+
+	reopened := openTestVault(t, dir)
+	cred, ok := reopened.Get(summary.ID)
+	if !ok {
+		t.Fatalf("credential %s missing after reopening", summary.ID)
+	}
+	if cred.Username != "root" || cred.Password != "s3cret" || cred.Name != "lobby" {
+		t.Errorf("reopened credential = %+v", cred)
+	}
+}
+
+func TestCredentialVaultTamperDetection(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(file *vaultFile)
+	}{
+		{
+			name: "ciphertext byte flipped",
+			tamper: func(file *vaultFile) {
+				ciphertext, _ := base64.StdEncoding.DecodeString(file.Ciphertext)
+				ciphertext[0] ^= 0x01
+				file.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+			},
+		},
+		{
+			name: "nonce changed",
+			tamper: func(file *vaultFile) {
+				nonce, _ := base64.StdEncoding.DecodeString(file.Nonce)
+				nonce[0] ^= 0x01
+				file.Nonce = base64.StdEncoding.EncodeToString(nonce)
+			},
+		},
+		{
+			name: "ciphertext truncated",
+			tamper: func(file *vaultFile) {
+				ciphertext, _ := base64.StdEncoding.DecodeString(file.Ciphertext)
+				file.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext[:len(ciphertext)-1])
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryKeyStore(t)
+			dir := t.TempDir()
+			vault := openTestVault(t, dir)
+			if _, err := vault.Add("lobby", "root", "s3cret", []string{"192.168.1.10"}); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+
+			path := filepath.Join(dir, vaultFileName)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var file vaultFile
+			if err := json.Unmarshal(data, &file); err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(&file)
+			data, _ = json.Marshal(file)
+			if err := os.WriteFile(path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := openCredentialVault(dir); err == nil {
+				t.Error("tampered vault opened without error")
+			}
+		})
+	}
+}
+
+func TestCredentialVaultWrongKey(t *testing.T) {
+	store := useMemoryKeyStore(t)
+	dir := t.TempDir()
+	vault := openTestVault(t, dir)
+	if _, err := vault.Add("lobby", "root", "s3cret", []string{"192.168.1.10"}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	// A copy of the config directory without the key store's key is useless
+	store.key = bytes.Repeat([]byte{0x42}, vaultKeySize)
+	if _, err := openCredentialVault(dir); err == nil || !strings.Contains(err.Error(), "wrong key") {
+		t.Errorf("openCredentialVault with another key: err = %v", err)
+	}
+}
+
+func TestCredentialVaultMigratesLegacyKeyFile(t *testing.T) {
+	useMemoryKeyStore(t)
+	dir := t.TempDir()
+	vault := openTestVault(t, dir)
+	summary, err := vault.Add("lobby", "root", "s3cret", []string{"192.168.1.10"})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		passphrase string
+	}{
+		{name: "key store", passphrase: ""},
+		{name: "passphrase", passphrase: "correct horse battery staple"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Recreate the layout of older versions: the hex key file beside the vault
+			legacyDir := t.TempDir()
+			legacyVault := &CredentialVault{
+				credentials: vault.credentials,
+				filePath:    filepath.Join(legacyDir, vaultFileName),
+				key:         bytes.Repeat([]byte{0x17}, vaultKeySize),
+			}
+			if err := legacyVault.save(); err != nil {
+				t.Fatal(err)
+			}
+			keyPath := filepath.Join(legacyDir, vaultKeyFileName)
+			if err := os.WriteFile(keyPath, []byte(hex.EncodeToString(legacyVault.key)), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			store := useMemoryKeyStore(t)
+			vaultKDFIterations = 1
+			t.Cleanup(func() { vaultKDFIterations = 600000 })
+			t.Setenv(vaultPassphraseEnv, tt.passphrase)
+
+			migrated := openTestVault(t, legacyDir)
+			if _, err := os.Stat(keyPath); !os.IsNotExist(err) {
+				t.Errorf("legacy key file still present: %v", err)
+			}
+			if tt.passphrase == "" && !bytes.Equal(store.key, legacyVault.key) {
+				t.Error("legacy key was not moved into the key store")
+			}
+			if _, ok := migrated.Get(summary.ID); !ok {
+				t.Fatal("credential missing after migration")
+			}
+
+			reopened := openTestVault(t, legacyDir)
+			if cred, ok := reopened.Get(summary.ID); !ok || cred.Password != "s3cret" {
+				t.Errorf("credential after reopening = %+v, %v", cred, ok)
+			}
+		})
+	}
+}
+
+func TestCredentialVaultPassphrase(t *testing.T) {
+	useMemoryKeyStore(t)
+	vaultKDFIterations = 1
+	t.Cleanup(func() { vaultKDFIterations = 600000 })
+	dir := t.TempDir()
+
+	t.Setenv(vaultPassphraseEnv, "correct horse battery staple")
+	vault := openTestVault(t, dir)
+	summary, err := vault.Add("lobby", "root", "s3cret", []string{"192.168.1.10"})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if cred, ok := openTestVault(t, dir).Get(summary.ID); !ok || cred.Password != "s3cret" {
+		t.Errorf("credential after reopening = %+v, %v", cred, ok)
+	}
+
+	t.Setenv(vaultPassphraseEnv, "wrong passphrase")
+	if _, err := openCredentialVault(dir); err == nil {
+		t.Error("vault opened with the wrong passphrase")
+	}
+}
+
+func TestCredentialVaultUnavailable(t *testing.T) {
+	original := openVaultKeyStore
+	openVaultKeyStore = func(string) (vaultKeyStore, error) { return nil, errNoVaultKeyStore }
+	t.Cleanup(func() { openVaultKeyStore = original })
+	t.Setenv(vaultPassphraseEnv, "")
+
+	vault, err := openCredentialVault(t.TempDir())
+	if err != nil {
+		t.Fatalf("openCredentialVault: %v", err)
+	}
+	if vault.Unavailable() == nil {
+		t.Fatal("vault without a key store is available")
+	}
+	if _, err := vault.Add("lobby", "root", "s3cret", []string{"192.168.1.10"}); err == nil {
+		t.Error("Add succeeded on an unavailable vault")
+	}
+	req := &ProxyRequest{URL: "http://192.168.1.10/", CredentialID: "cred_x"}
+	if err := vault.Resolve(req); err == nil || !strings.Contains(err.Error(), vaultPassphraseEnv) {
+		t.Errorf("Resolve on an unavailable vault: err = %v", err)
+	}
+}
+
+func TestCredentialVaultRotate(t *testing.T) {
+	useMemoryKeyStore(t)
+	vault := openTestVault(t, t.TempDir())
+	summary, err := vault.Add("lobby", "root", "s3cret", []string{"192.168.1.10"})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	if _, err := vault.Rotate(summary.ID, "", "", nil); err == nil {
+		t.Error("Rotate accepted an empty password")
+	}
+	if cred, _ := vault.Get(summary.ID); cred.Password != "s3cret" {
+		t.Errorf("password after rejected rotate = %q", cred.Password)
+	}
+
+	if _, err := vault.Rotate(summary.ID, "", "n3w", nil); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	cred, _ := vault.Get(summary.ID)
+	if cred.Username != "root" || cred.Password != "n3w" {
+		t.Errorf("rotated credential = %+v", cred)
+	}
+}
+
+func TestCredentialAllowsHost(t *testing.T) {
+	cred := CredentialSet{AllowedHosts: []string{"192.168.1.10", "10.0.0.0/24", "camera.local", "fd00::/64"}} // Normalized as Add stores them
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"192.168.1.10", true},
+		{"192.168.1.11", false},
+		{"10.0.0.200", true},
+		{"10.0.1.1", false},
+		{"camera.local", true},
+		{"CAMERA.LOCAL", true},
+		{"other.local", false},
+		{"fd00::1", true},
+		{"[fd00::1]", true},
+		{"fd01::1", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := cred.AllowsHost(tt.host); got != tt.want {
+				t.Errorf("AllowsHost(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+
+	if (&CredentialSet{}).AllowsHost("192.168.1.10") {
+		t.Error("credential without allowed hosts allows every host")
+	}
+}
+
+func TestCredentialVaultResolveChecksHost(t *testing.T) {
+	useMemoryKeyStore(t)
+	vault := openTestVault(t, t.TempDir())
+	summary, err := vault.Add("lobby", "root", "s3cret", []string{"192.168.1.0/24"})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{name: "allowed host", url: "https://192.168.1.10/axis-cgi/basicdeviceinfo.cgi"},
+		{name: "allowed host with port", url: "http://192.168.1.10:8080/"},
+		{name: "other host", url: "http://192.168.2.10/", wantErr: true},
+		{name: "userinfo trick", url: "http://192.168.1.10@evil.example/", wantErr: true},
+		{name: "invalid URL", url: "not a url", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &ProxyRequest{URL: tt.url, CredentialID: summary.ID}
+			err := vault.Resolve(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve(%s) err = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+			if !tt.wantErr && (req.Username != "root" || req.Password != "s3cret") {
+				t.Errorf("Resolve filled %q/%q", req.Username, req.Password)
+			}
+			if tt.wantErr && req.Password != "" {
+				t.Error("password filled in for a rejected host")
+			}
+		})
+	}
+}
+
+func TestPBKDF2SHA256(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		iterations     int
+		keyLen         int
+		want           string
+	}{
+		{"password", "salt", 1, 32, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 4096, 32, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 40,
+			"348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"},
+	}
+
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iterations, tt.keyLen))
+		if got != tt.want {
+			t.Errorf("pbkdf2SHA256(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The vault key is never stored beside credentials.vault in the clear:
+//   - macOS: login Keychain (security tool)
+//   - Windows: credentials.dpapi, sealed with DPAPI for the current user
+//   - Linux: Secret Service keyring such as GNOME Keyring or KWallet (secret-tool)
+//   - Any platform: with ANAVA_VAULT_PASSPHRASE set, the key is derived from the
+//     passphrase with PBKDF2-SHA256 instead (hosts without a key store)
+//
+// A copy of ~/.config/anava (backups, sync folders, another account, a disk image)
+// is therefore not enough to decrypt the vault. Code running as the same logged-in
+// user can still ask the key store for the key, just like the connector does; that
+// is out of scope. Without a key store or passphrase the vault stays unavailable
+// rather than falling back to a key file
+
+const (
+	vaultKeyService    = "anava-credential-vault" // Keychain / Secret Service entry
+	vaultSaltFileName  = "credentials.salt"
+	vaultPassphraseEnv = "ANAVA_VAULT_PASSPHRASE"
+	vaultKeySize       = 32 // AES-256
+)
+
+// vaultKDFIterations is the PBKDF2 iteration count for passphrase keys (OWASP 2023)
+var vaultKDFIterations = 600000
+
+var (
+	errVaultKeyNotFound = errors.New("no vault key stored yet")
+	errNoVaultKeyStore  = errors.New("no OS key store available")
+)
+
+// vaultKeyStore keeps the vault key outside the config directory
+type vaultKeyStore interface {
+	// Load returns the stored key, or errVaultKeyNotFound
+	Load() ([]byte, error)
+	Store(key []byte) error
+	String() string
+}
+
+// openVaultKeyStore returns the platform key store for the vault in dir (replaced in tests)
+var openVaultKeyStore = platformVaultKeyStore
+
+// loadVaultKey returns the key for the vault in dir, creating and storing one on first use
+// legacyKey (from an older plain key file), if set, becomes the stored key
+func loadVaultKey(dir string, legacyKey []byte) ([]byte, error) {
+	if passphrase := os.Getenv(vaultPassphraseEnv); passphrase != "" {
+		return passphraseVaultKey(dir, passphrase)
+	}
+
+	store, err := openVaultKeyStore(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w (set %s to use a passphrase instead)", err, vaultPassphraseEnv)
+	}
+
+	key, err := store.Load()
+	if err == nil {
+		if len(key) != vaultKeySize {
+			return nil, fmt.Errorf("invalid credential vault key in %s", store)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, errVaultKeyNotFound) {
+		return nil, fmt.Errorf("failed to read credential vault key from %s: %w", store, err)
+	}
+
+	key = legacyKey
+	if key == nil {
+		key = make([]byte, vaultKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate credential vault key: %w", err)
+		}
+	}
+	if err := store.Store(key); err != nil {
+		return nil, fmt.Errorf("failed to store credential vault key in %s: %w", store, err)
+	}
+	// Read it back: a key that can't be loaded next time would lock the vault for good
+	stored, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read back credential vault key from %s: %w", store, err)
+	}
+	if !hmac.Equal(stored, key) {
+		return nil, fmt.Errorf("credential vault key read back from %s does not match", store)
+	}
+	return key, nil
+}
+
+// readLegacyVaultKey reads the hex key file written by older versions, or nil if there is none
+func readLegacyVaultKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read credential vault key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != vaultKeySize {
+		return nil, fmt.Errorf("invalid credential vault key in %s", path)
+	}
+	return key, nil
+}
+
+// passphraseVaultKey derives the vault key from passphrase and the salt stored in dir
+// The salt isn't secret; it only keeps equal passphrases from giving equal keys
+func passphraseVaultKey(dir, passphrase string) ([]byte, error) {
+	saltPath := filepath.Join(dir, vaultSaltFileName)
+	salt, err := os.ReadFile(saltPath)
+	if os.IsNotExist(err) {
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("failed to generate credential vault salt: %w", err)
+		}
+		if err := os.WriteFile(saltPath, salt, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write credential vault salt: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read credential vault salt: %w", err)
+	}
+	if len(salt) < 16 {
+		return nil, fmt.Errorf("invalid credential vault salt in %s", saltPath)
+	}
+
+	return pbkdf2SHA256([]byte(passphrase), salt, vaultKDFIterations, vaultKeySize), nil
+}
+
+// pbkdf2SHA256 derives a keyLen-byte key with PBKDF2 (RFC 8018) using HMAC-SHA256
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	blocks := (keyLen + prf.Size() - 1) / prf.Size()
+
+	var key []byte
+	counter := make([]byte, 4)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter, uint32(block))
+		prf.Write(counter)
+		u := prf.Sum(nil)
+
+		t := append([]byte(nil), u...)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
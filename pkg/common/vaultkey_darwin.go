@@ -0,0 +1,55 @@
+package common
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// errSecItemNotFound is the exit status of security find-generic-password for a missing item
+const errSecItemNotFound = 44
+
+// keychainKeyStore keeps the vault key in the login Keychain
+// The account is the config directory, so separate homes never share a key
+type keychainKeyStore struct {
+	account string
+}
+
+// platformVaultKeyStore returns the Keychain key store
+func platformVaultKeyStore(dir string) (vaultKeyStore, error) {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil, errNoVaultKeyStore
+	}
+	return &keychainKeyStore{account: dir}, nil
+}
+
+func (ks *keychainKeyStore) Load() ([]byte, error) {
+	out, err := exec.Command("security", "find-generic-password",
+		"-s", vaultKeyService, "-a", ks.account, "-w").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == errSecItemNotFound {
+			return nil, errVaultKeyNotFound
+		}
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(out)))
+}
+
+func (ks *keychainKeyStore) Store(key []byte) error {
+	// Interactive mode reads the command from stdin, keeping the secret off the command line
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %q -l %q -w %s\n",
+		vaultKeyService, ks.account, "Anava credential vault key", hex.EncodeToString(key)))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (ks *keychainKeyStore) String() string {
+	return "the login Keychain"
+}
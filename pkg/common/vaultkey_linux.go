@@ -0,0 +1,55 @@
+package common
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// secretServiceKeyStore keeps the vault key in the Secret Service keyring (GNOME Keyring, KWallet)
+// The account attribute is the config directory, so separate homes never share a key
+type secretServiceKeyStore struct {
+	account string
+}
+
+// platformVaultKeyStore returns the Secret Service key store if secret-tool (libsecret) is installed
+func platformVaultKeyStore(dir string) (vaultKeyStore, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return nil, fmt.Errorf("%w: secret-tool (libsecret) is not installed", errNoVaultKeyStore)
+	}
+	return &secretServiceKeyStore{account: dir}, nil
+}
+
+func (ks *secretServiceKeyStore) Load() ([]byte, error) {
+	out, err := exec.Command("secret-tool", "lookup",
+		"service", vaultKeyService, "account", ks.account).Output()
+	if err != nil {
+		// A missing item exits 1 without a message; keyring errors explain themselves
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) == 0 && len(out) == 0 {
+			return nil, errVaultKeyNotFound
+		}
+		if exitErr != nil && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(out)))
+}
+
+func (ks *secretServiceKeyStore) Store(key []byte) error {
+	// The secret goes through stdin, never the command line
+	cmd := exec.Command("secret-tool", "store", "--label=Anava credential vault key",
+		"service", vaultKeyService, "account", ks.account)
+	cmd.Stdin = strings.NewReader(hex.EncodeToString(key))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (ks *secretServiceKeyStore) String() string {
+	return "the Secret Service keyring"
+}
@@ -0,0 +1,8 @@
+//go:build !darwin && !linux && !windows
+
+package common
+
+// platformVaultKeyStore reports that this platform has no supported key store
+func platformVaultKeyStore(dir string) (vaultKeyStore, error) {
+	return nil, errNoVaultKeyStore
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// dpapiKeyFileName holds the vault key sealed with DPAPI
+const dpapiKeyFileName = "credentials.dpapi"
+
+// cryptProtectUIForbidden fails instead of prompting the user
+const cryptProtectUIForbidden = 0x1
+
+var (
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = kernel32.NewProc("LocalFree")
+)
+
+// dataBlob is the DATA_BLOB structure used by DPAPI
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+func newDataBlob(b []byte) *dataBlob {
+	if len(b) == 0 {
+		return &dataBlob{}
+	}
+	return &dataBlob{size: uint32(len(b)), data: &b[0]}
+}
+
+// takeBytes copies the blob's contents and frees the memory DPAPI allocated for it
+func (blob *dataBlob) takeBytes() []byte {
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(blob.data)))
+	return append([]byte(nil), unsafe.Slice(blob.data, blob.size)...)
+}
+
+// dpapiKeyStore keeps the vault key in a file sealed with DPAPI for the current user,
+// so the file is useless on another account or machine
+type dpapiKeyStore struct {
+	path string
+}
+
+// platformVaultKeyStore returns the DPAPI key store
+func platformVaultKeyStore(dir string) (vaultKeyStore, error) {
+	if err := procCryptProtectData.Find(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoVaultKeyStore, err)
+	}
+	return &dpapiKeyStore{path: filepath.Join(dir, dpapiKeyFileName)}, nil
+}
+
+func (ks *dpapiKeyStore) Load() ([]byte, error) {
+	data, err := os.ReadFile(ks.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errVaultKeyNotFound
+		}
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sealed key: %w", err)
+	}
+
+	var out dataBlob
+	r, _, err := procCryptUnprotectData.Call(uintptr(unsafe.Pointer(newDataBlob(sealed))),
+		0, 0, 0, 0, cryptProtectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, fmt.Errorf("CryptUnprotectData: %w", err)
+	}
+	return out.takeBytes(), nil
+}
+
+func (ks *dpapiKeyStore) Store(key []byte) error {
+	var out dataBlob
+	r, _, err := procCryptProtectData.Call(uintptr(unsafe.Pointer(newDataBlob(key))),
+		0, 0, 0, 0, cryptProtectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return fmt.Errorf("CryptProtectData: %w", err)
+	}
+
+	tmpPath := ks.path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(base64.StdEncoding.EncodeToString(out.takeBytes())), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, ks.path)
+}
+
+func (ks *dpapiKeyStore) String() string {
+	return ks.path + " (DPAPI)"
+}
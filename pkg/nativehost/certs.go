@@ -0,0 +1,114 @@
+package nativehost
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"anava-camera-extension/pkg/common"
+)
+
+// handleCertRequest forwards certificate store messages to the proxy /certs endpoints
+func handleCertRequest(logger *log.Logger, req *Request) error {
+	logger.Printf("Handling %s request (host: %s)", req.Type, req.Host)
+
+	var (
+		method  = "GET"
+		path    string
+		payload interface{}
+	)
+
+	switch req.Type {
+	case TypeListCerts:
+		path = "/certs"
+	case TypeGetCert:
+		if req.Host == "" {
+			return sendError("Missing required field: host")
+		}
+		path = "/certs?host=" + url.QueryEscape(req.Host)
+	case TypeDeleteCert:
+		if req.Host == "" {
+			return sendError("Missing required field: host")
+		}
+		method = "DELETE"
+		path = "/certs?host=" + url.QueryEscape(req.Host)
+	case TypeAcceptCert:
+		method = "POST"
+		path = "/certs/accept"
+		payload = map[string]string{"host": req.Host, "fingerprint": req.Fingerprint}
+	case TypeImportCerts:
+		method = "POST"
+		path = "/certs/import"
+		payload = map[string]interface{}{"certificates": req.Certificates, "aliases": req.Aliases, "replace": req.Replace}
+	case TypeExportCerts:
+		path = "/certs/export"
+	case TypeExpiringCerts:
+		path = "/certs/expiring"
+		if req.Days > 0 {
+			path += "?days=" + strconv.Itoa(req.Days)
+		}
+	}
+
+	data, err := callProxyAPI(logger, method, path, payload)
+	if err != nil {
+		logger.Printf("Certificate request failed: %v", err)
+		return sendErrorCode(fmt.Sprintf("Certificate request failed: %v", err), common.ClassifyError(err))
+	}
+
+	return sendMessage(Response{
+		Success: true,
+		Data:    data,
+	})
+}
+
+// callProxyAPI sends payload (if any) as JSON to a proxy server endpoint and decodes the JSON reply
+func callProxyAPI(logger *log.Logger, method, path string, payload interface{}) (map[string]interface{}, error) {
+	var body io.Reader
+	if payload != nil {
+		bodyBytes, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(bodyBytes)
+	}
+
+	logger.Printf("Calling proxy server: %s %s", method, path)
+
+	httpReq, err := http.NewRequest(method, proxyServerBaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	if payload != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, proxyUnreachableError(err)
+	}
+	defer httpResp.Body.Close()
+
+	respBytes, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxy response: %w", err)
+	}
+
+	if httpResp.StatusCode >= 400 {
+		return nil, errors.New(strings.TrimSpace(string(respBytes)))
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(respBytes, &data); err != nil {
+		return nil, common.NewProxyError(common.ErrCodeUnknown, fmt.Errorf("failed to decode proxy response: %w", err))
+	}
+	return data, nil
+}
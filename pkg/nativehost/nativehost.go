@@ -23,20 +23,47 @@ const (
 	TypeHealthCheck          = "HEALTH_CHECK"
 	TypeConfigure            = "CONFIGURE"
 	TypeCheckOldInstallation = "CHECK_OLD_INSTALLATION"
+	TypeListCerts            = "LIST_CERTS"
+	TypeGetCert              = "GET_CERT"
+	TypeDeleteCert           = "DELETE_CERT"
+	TypeAcceptCert           = "ACCEPT_CERT"
+	TypeImportCerts          = "IMPORT_CERTS"
+	TypeExportCerts          = "EXPORT_CERTS"
+	TypeExpiringCerts        = "EXPIRING_CERTS"
 )
 
 // Request represents incoming message from Chrome extension
 type Request struct {
-	Type     string                 `json:"type"`
-	URL      string                 `json:"url,omitempty"`
-	Method   string                 `json:"method,omitempty"`
-	Username string                 `json:"username,omitempty"`
-	Password string                 `json:"password,omitempty"`
-	Body     map[string]interface{} `json:"body,omitempty"`
+	Type     string      `json:"type"`
+	URL      string      `json:"url,omitempty"`
+	Method   string      `json:"method,omitempty"`
+	Username string      `json:"username,omitempty"`
+	Password string      `json:"password,omitempty"`
+	Body     interface{} `json:"body,omitempty"`
+	// Vault credential used instead of username/password (see common.CredentialVault)
+	CredentialID string `json:"credentialId,omitempty"`
+	// Body encoding for PROXY_REQUEST (see common.BodyEncoding*)
+	BodyEncoding   string `json:"bodyEncoding,omitempty"`
+	ContentType    string `json:"contentType,omitempty"`
+	LegacyResponse bool   `json:"legacyResponse,omitempty"`
+	// Extra headers, query parameters and timeouts for PROXY_REQUEST
+	Headers        map[string]string `json:"headers,omitempty"`
+	Query          map[string]string `json:"query,omitempty"`
+	ProbeTimeoutMs int               `json:"probeTimeoutMs,omitempty"`
+	TimeoutMs      int               `json:"timeoutMs,omitempty"`
 	// For CONFIGURE message
 	BackendURL string `json:"backendUrl,omitempty"`
 	ProjectID  string `json:"projectId,omitempty"`
 	Nonce      string `json:"nonce,omitempty"`
+	// Optional certificate pin policy: warn, tofu or pre-approved (applied on proxy restart)
+	CertPinPolicy string `json:"certPinPolicy,omitempty"`
+	// For certificate store messages (see proxy /certs endpoints)
+	Host         string            `json:"host,omitempty"`
+	Fingerprint  string            `json:"fingerprint,omitempty"`
+	Certificates map[string]string `json:"certificates,omitempty"`
+	Aliases      map[string]string `json:"aliases,omitempty"` // IMPORT_CERTS hostname:port -> ip:port, as exported
+	Replace      bool              `json:"replace,omitempty"`
+	Days         int               `json:"days,omitempty"` // EXPIRING_CERTS window (default 30)
 }
 
 // Response represents outgoing message to Chrome extension
@@ -46,9 +73,25 @@ type Response struct {
 	Status  int                    `json:"status,omitempty"`
 	Data    map[string]interface{} `json:"data,omitempty"`
 	Error   string                 `json:"error,omitempty"`
+	// ErrorCode classifies Error for PROXY_REQUEST and certificate messages (see common.ErrorCode)
+	ErrorCode common.ErrorCode `json:"errorCode,omitempty"`
+	// Camera response passthrough for PROXY_REQUEST (see common.ProxyResponse)
+	Headers      http.Header `json:"headers,omitempty"`
+	ContentType  string      `json:"contentType,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"bodyEncoding,omitempty"`
 }
 
-const proxyServerURL = "http://127.0.0.1:9876/proxy"
+const (
+	proxyServerBaseURL = "http://127.0.0.1:9876"
+	proxyServerURL     = proxyServerBaseURL + "/proxy"
+
+	// proxyRoundTrips is the most camera round trips the proxy makes after the probe:
+	// a remembered scheme, then Digest (challenge + answer) and Basic
+	proxyRoundTrips = 4
+	// proxyTimeoutMargin covers the local hop and the proxy's own work
+	proxyTimeoutMargin = 5 * time.Second
+)
 
 // Run starts the native messaging host
 func Run(logger *log.Logger) error {
@@ -82,6 +125,9 @@ func Run(logger *log.Logger) error {
 	case TypeCheckOldInstallation:
 		return handleCheckOldInstallation(logger)
 
+	case TypeListCerts, TypeGetCert, TypeDeleteCert, TypeAcceptCert, TypeImportCerts, TypeExportCerts, TypeExpiringCerts:
+		return handleCertRequest(logger, req)
+
 	case TypeProxyRequest, "": // Empty type defaults to proxy request for backwards compatibility
 		return handleProxyRequest(logger, req)
 
@@ -140,10 +186,17 @@ func handleConfigure(logger *log.Logger, req *Request) error {
 		return sendError(fmt.Sprintf("Failed to create config storage: %v", err))
 	}
 
-	config := &common.Config{
-		BackendURL:   req.BackendURL,
-		ProjectID:    req.ProjectID,
-		SessionToken: sessionToken,
+	// Keep settings not covered by CONFIGURE (e.g. the certificate pin policy)
+	config, err := configStorage.Load()
+	if err != nil {
+		logger.Printf("Failed to load existing config, starting fresh: %v", err)
+		config = &common.Config{}
+	}
+	config.BackendURL = req.BackendURL
+	config.ProjectID = req.ProjectID
+	config.SessionToken = sessionToken
+	if req.CertPinPolicy != "" {
+		config.CertPinPolicy = req.CertPinPolicy
 	}
 
 	if err := configStorage.Save(config); err != nil {
@@ -155,8 +208,8 @@ func handleConfigure(logger *log.Logger, req *Request) error {
 	resp := Response{
 		Success: true,
 		Data: map[string]interface{}{
-			"configured":   true,
-			"projectId":    req.ProjectID,
+			"configured":    true,
+			"projectId":     req.ProjectID,
 			"authenticated": true,
 		},
 	}
@@ -263,30 +316,43 @@ func getOldVersionMessage(hasOldVersion bool) string {
 
 func handleProxyRequest(logger *log.Logger, req *Request) error {
 	// SECURITY: Sanitize credentials in logs
-	logger.Printf("Handling proxy request: method=%s url=%s username=%s",
-		req.Method, req.URL, common.SanitizeCredential(req.Username))
+	logger.Printf("Handling proxy request: method=%s url=%s username=%s credentialId=%s",
+		req.Method, req.URL, common.SanitizeCredential(req.Username), req.CredentialID)
 
 	// Forward to local proxy server
 	proxyReq := &common.ProxyRequest{
-		URL:      req.URL,
-		Method:   req.Method,
-		Username: req.Username,
-		Password: req.Password,
-		Body:     req.Body,
+		URL:            req.URL,
+		Method:         req.Method,
+		Username:       req.Username,
+		Password:       req.Password,
+		Body:           req.Body,
+		CredentialID:   req.CredentialID,
+		BodyEncoding:   req.BodyEncoding,
+		ContentType:    req.ContentType,
+		LegacyResponse: req.LegacyResponse,
+		Headers:        req.Headers,
+		Query:          req.Query,
+		ProbeTimeoutMs: req.ProbeTimeoutMs,
+		TimeoutMs:      req.TimeoutMs,
 	}
 
 	resp, err := forwardToProxy(logger, proxyReq)
 	if err != nil {
 		logger.Printf("Error forwarding to proxy: %v", err)
-		return sendError(fmt.Sprintf("Proxy request failed: %v", err))
+		return sendErrorCode(fmt.Sprintf("Proxy request failed: %v", err), common.ClassifyError(err))
 	}
 
 	// Convert ProxyResponse to Response
 	response := Response{
-		Success: resp.Status < 400,
-		Status:  resp.Status,
-		Data:    resp.Data,
-		Error:   resp.Error,
+		Success:      resp.ErrorCode == "" && resp.Status < 400,
+		Status:       resp.Status,
+		Data:         resp.Data,
+		Error:        resp.Error,
+		ErrorCode:    resp.ErrorCode,
+		Headers:      resp.Headers,
+		ContentType:  resp.ContentType,
+		Body:         resp.Body,
+		BodyEncoding: resp.BodyEncoding,
 	}
 
 	// Send response back to Chrome
@@ -344,6 +410,39 @@ func sendError(errMsg string) error {
 	return sendMessage(resp)
 }
 
+// sendErrorCode sends an error classified with code
+func sendErrorCode(errMsg string, code common.ErrorCode) error {
+	return sendMessage(Response{
+		Success:   false,
+		Error:     errMsg,
+		ErrorCode: code,
+	})
+}
+
+// proxyUnreachableError wraps a failed call to the local proxy server
+// A timeout keeps its code; any other failure means the proxy server can't be reached
+func proxyUnreachableError(err error) error {
+	code := common.ErrCodeConnectionRefused
+	if common.IsTimeoutError(err) {
+		code = common.ErrCodeTimeout
+	}
+	return common.NewProxyError(code, fmt.Errorf("proxy server request failed (is proxy server running?): %w", err))
+}
+
+// proxyTimeout bounds the wait for the proxy server, derived from req's own timeouts
+// so a proxy that stops answering can't hang the extension's call forever
+func proxyTimeout(req *common.ProxyRequest) time.Duration {
+	probe := common.DefaultProbeTimeout
+	if req.ProbeTimeoutMs > 0 {
+		probe = time.Duration(req.ProbeTimeoutMs) * time.Millisecond
+	}
+	roundTrip := common.DefaultRequestTimeout
+	if req.TimeoutMs > 0 {
+		roundTrip = time.Duration(req.TimeoutMs) * time.Millisecond
+	}
+	return probe + proxyRoundTrips*roundTrip + proxyTimeoutMargin
+}
+
 func forwardToProxy(logger *log.Logger, req *common.ProxyRequest) (common.ProxyResponse, error) {
 	// Create request body
 	bodyBytes, err := json.Marshal(req)
@@ -362,19 +461,19 @@ func forwardToProxy(logger *log.Logger, req *common.ProxyRequest) (common.ProxyR
 	httpReq.Header.Set("Content-Type", "application/json")
 
 	// Execute request
-	client := &http.Client{}
+	client := &http.Client{Timeout: proxyTimeout(req)}
 	httpResp, err := client.Do(httpReq)
 	if err != nil {
-		return common.ProxyResponse{}, fmt.Errorf("proxy server request failed (is proxy server running?): %w", err)
+		return common.ProxyResponse{}, proxyUnreachableError(err)
 	}
 	defer httpResp.Body.Close()
 
 	// Parse response
 	var resp common.ProxyResponse
 	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
-		return common.ProxyResponse{}, fmt.Errorf("failed to decode proxy response: %w", err)
+		return common.ProxyResponse{}, common.NewProxyError(common.ErrCodeUnknown, fmt.Errorf("failed to decode proxy response: %w", err))
 	}
 
-	logger.Printf("Proxy response: status=%d", resp.Status)
+	logger.Printf("Proxy response: status=%d errorCode=%s", resp.Status, resp.ErrorCode)
 	return resp, nil
 }
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// importCertificatesPayload is the body accepted by /certs/import
+type importCertificatesPayload struct {
+	Certificates map[string]string `json:"certificates"`      // ip:port -> SHA256 fingerprint, as exported
+	Aliases      map[string]string `json:"aliases,omitempty"` // hostname:port -> ip:port
+	Replace      bool              `json:"replace,omitempty"`
+}
+
+// acceptCertificatePayload is the body accepted by /certs/accept
+type acceptCertificatePayload struct {
+	Host        string `json:"host"`                  // ip, ip:port or hostname alias
+	Fingerprint string `json:"fingerprint,omitempty"` // Empty accepts the last rejected certificate
+}
+
+// handleAcceptCertificate pins a new fingerprint for a host after a legitimate certificate rotation
+func (ps *ProxyServer) handleAcceptCertificate(w http.ResponseWriter, r *http.Request) {
+	if !ps.requireTrustedOrigin(w, r) {
+		return
+	}
+	ps.setCORSHeaders(w, r)
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var payload acceptCertificatePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		ps.logger.Printf("Failed to decode accept certificate request: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	host, fingerprint, err := ps.certStore.AcceptFingerprint(payload.Host, payload.Fingerprint)
+	if err != nil {
+		ps.logger.Printf("Failed to accept certificate for %s: %v", payload.Host, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"host":        host,
+		"fingerprint": fingerprint,
+		"policy":      ps.certStore.Policy(),
+	})
+}
+
+// handleCertificates lists (GET), inspects (GET ?host=) and deletes (DELETE ?host=) pinned certificates
+// Reads are gated too: the list is an inventory of camera addresses
+func (ps *ProxyServer) handleCertificates(w http.ResponseWriter, r *http.Request) {
+	if !ps.requireTrustedOrigin(w, r) {
+		return
+	}
+	ps.setCORSHeaders(w, r)
+
+	host := r.URL.Query().Get("host")
+
+	switch r.Method {
+	case "OPTIONS":
+		w.WriteHeader(http.StatusOK)
+
+	case "GET":
+		if host == "" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"policy":       ps.certStore.Policy(),
+				"certificates": ps.certStore.List(),
+			})
+			return
+		}
+
+		pin, ok := ps.certStore.Get(host)
+		if !ok {
+			http.Error(w, "no pinned certificate for "+host, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pin)
+
+	case "DELETE":
+		if host == "" {
+			http.Error(w, "host required", http.StatusBadRequest)
+			return
+		}
+
+		if err := ps.certStore.Delete(host); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		ps.logger.Printf("Deleted pinned certificate for %s", host)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleImportCertificates adds pins from an exported certificate store
+func (ps *ProxyServer) handleImportCertificates(w http.ResponseWriter, r *http.Request) {
+	if !ps.requireTrustedOrigin(w, r) {
+		return
+	}
+	ps.setCORSHeaders(w, r)
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var payload importCertificatesPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		ps.logger.Printf("Failed to decode import certificates request: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	imported, err := ps.certStore.Import(payload.Certificates, payload.Aliases, payload.Replace)
+	if err != nil {
+		ps.logger.Printf("Failed to import certificates: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ps.logger.Printf("Imported %d pinned certificates (replace: %v)", imported, payload.Replace)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"imported": imported})
+}
+
+// handleExportCertificates returns all pins in a form accepted by /certs/import
+func (ps *ProxyServer) handleExportCertificates(w http.ResponseWriter, r *http.Request) {
+	if !ps.requireTrustedOrigin(w, r) {
+		return
+	}
+	ps.setCORSHeaders(w, r)
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	certificates, aliases := ps.certStore.Export()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(importCertificatesPayload{
+		Certificates: certificates,
+		Aliases:      aliases,
+	})
+}
+
+// handleExpiringCertificates lists pinned certificates that have expired or expire
+// within ?days= (default 30)
+func (ps *ProxyServer) handleExpiringCertificates(w http.ResponseWriter, r *http.Request) {
+	if !ps.requireTrustedOrigin(w, r) {
+		return
+	}
+	ps.setCORSHeaders(w, r)
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	within := certExpiryWarning
+	if days := r.URL.Query().Get("days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			http.Error(w, "days must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		within = time.Duration(n) * 24 * time.Hour
+	}
+
+	certificates := ps.certStore.Expiring(within)
+	if certificates == nil {
+		certificates = []PinnedCertificate{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"withinDays":   int(within / (24 * time.Hour)),
+		"certificates": certificates,
+	})
+}
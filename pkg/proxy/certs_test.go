@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestProxyServer creates a proxy server whose stores live in a temporary home directory
+func newTestProxyServer(t *testing.T) *ProxyServer {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("ANAVA_VAULT_PASSPHRASE", "test-passphrase") // Keep the vault key out of the real key store
+	ps, err := NewProxyServer(log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("NewProxyServer: %v", err)
+	}
+	return ps
+}
+
+func TestCertificateRoutesRequireTrustedOrigin(t *testing.T) {
+	ps := newTestProxyServer(t)
+	if _, err := ps.certStore.Import(map[string]string{"192.168.1.10:443": strings.Repeat("ab", 32)}, nil, false); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	routes := []struct {
+		name    string
+		method  string
+		target  string
+		handler http.HandlerFunc
+	}{
+		{"list", "GET", "/certs", ps.handleCertificates},
+		{"get", "GET", "/certs?host=192.168.1.10", ps.handleCertificates},
+		{"delete", "DELETE", "/certs?host=192.168.1.10", ps.handleCertificates},
+		{"export", "GET", "/certs/export", ps.handleExportCertificates},
+		{"expiring", "GET", "/certs/expiring", ps.handleExpiringCertificates},
+		{"accept", "POST", "/certs/accept", ps.handleAcceptCertificate},
+		{"import", "POST", "/certs/import", ps.handleImportCertificates},
+	}
+
+	for _, route := range routes {
+		t.Run(route.name, func(t *testing.T) {
+			req := httptest.NewRequest(route.method, route.target, strings.NewReader("{}"))
+			req.Header.Set("Origin", "https://evil.example")
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			route.handler(rec, req)
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+			}
+			if strings.Contains(rec.Body.String(), "192.168.1.10") {
+				t.Errorf("response leaks the pinned address: %s", rec.Body.String())
+			}
+		})
+	}
+
+	// Trusted origins still get the list
+	req := httptest.NewRequest("GET", "/certs", nil)
+	req.Header.Set("Origin", "chrome-extension://ojhdgnojgelfiejpgipjddfddgefdpfa")
+	rec := httptest.NewRecorder()
+	ps.handleCertificates(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "192.168.1.10:443") {
+		t.Errorf("trusted list: status %d, body %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestImportEmptyReplaceRejected(t *testing.T) {
+	ps := newTestProxyServer(t)
+	pins := map[string]string{"192.168.1.10:443": strings.Repeat("ab", 32)}
+	if _, err := ps.certStore.Import(pins, nil, false); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	if _, err := ps.certStore.Import(nil, nil, true); err == nil {
+		t.Error("replace with no certificates succeeded")
+	}
+	if _, ok := ps.certStore.Lookup("192.168.1.10:443", ""); !ok {
+		t.Error("rejected replace removed existing pins")
+	}
+
+	// A non-empty replace still drops everything not imported
+	if _, err := ps.certStore.Import(map[string]string{"192.168.1.11:443": strings.Repeat("cd", 32)}, nil, true); err != nil {
+		t.Fatalf("Import replace: %v", err)
+	}
+	if _, ok := ps.certStore.Lookup("192.168.1.10:443", ""); ok {
+		t.Error("replace kept a pin that wasn't imported")
+	}
+}
@@ -0,0 +1,696 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"anava-camera-extension/pkg/common"
+)
+
+const (
+	certStoreVersion = 3
+	// certExpiryWarning is how far ahead expiring camera certificates are reported
+	certExpiryWarning = 30 * 24 * time.Hour
+	// lastSeenSaveInterval limits disk writes when only LastSeen changes
+	lastSeenSaveInterval = time.Hour
+	// maxCertHistory is the number of replaced certificates kept per address
+	maxCertHistory = 10
+)
+
+// Expiry states reported for pinned certificates
+const (
+	CertExpiryOK       = "ok"
+	CertExpiryExpiring = "expiring"
+	CertExpiryExpired  = "expired"
+)
+
+// CertificateInfo is the metadata recorded for a camera certificate
+// Pins imported or migrated from older stores only have a fingerprint until the camera is seen again
+type CertificateInfo struct {
+	Fingerprint string    `json:"fingerprint"`
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	SANs        []string  `json:"sans,omitempty"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+}
+
+// certEntry is the pin for one camera address
+type certEntry struct {
+	CertificateInfo
+	History []CertificateInfo `json:"history,omitempty"` // Previously pinned certificates, newest first
+	// ChainValidated entries come from cameras validated by certificate chain: their
+	// metadata is kept for expiry reporting, but the fingerprint is not a pin
+	ChainValidated bool `json:"chainValidated,omitempty"`
+}
+
+// certStoreFile is the on-disk format of certificate-fingerprints.json
+// Version 1 files were a bare host -> fingerprint map keyed by TLS ServerName;
+// version 2 keyed fingerprint strings by ip:port
+type certStoreFile struct {
+	Version int                   `json:"version"`
+	Pins    map[string]*certEntry `json:"pins"`              // ip:port -> pinned certificate
+	Aliases map[string]string     `json:"aliases,omitempty"` // hostname:port -> ip:port
+}
+
+// certStoreFileV2 is the version 2 on-disk format
+type certStoreFileV2 struct {
+	Pins    map[string]string `json:"pins"`
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+// CertificateStore manages certificate fingerprints for known cameras
+// Pins are keyed by the dialed ip:port; hostnames used to reach a camera are
+// recorded as aliases of that address
+type CertificateStore struct {
+	mu       sync.RWMutex
+	saveMu   sync.Mutex                 // Serializes save
+	entries  map[string]*certEntry      // ip:port -> pinned certificate
+	aliases  map[string]string          // hostname:port -> ip:port
+	pending  map[string]CertificateInfo // ip:port -> last rejected certificate, awaiting AcceptFingerprint
+	policy   common.PinPolicy
+	filePath string
+	logger   *log.Logger
+}
+
+// NewCertificateStore creates a new certificate store
+func NewCertificateStore(logger *log.Logger) (*CertificateStore, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	// macOS: ~/Library/Application Support/Anava/
+	// Linux: ~/.local/share/anava/
+	var certStoreDir string
+	switch {
+	case fileExists(filepath.Join(homeDir, "Library")): // macOS
+		certStoreDir = filepath.Join(homeDir, "Library", "Application Support", "Anava")
+	default: // Linux/Windows
+		certStoreDir = filepath.Join(homeDir, ".local", "share", "anava")
+	}
+
+	if err := os.MkdirAll(certStoreDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cert store directory: %w", err)
+	}
+
+	certStoreFile := filepath.Join(certStoreDir, "certificate-fingerprints.json")
+
+	store := &CertificateStore{
+		entries:  make(map[string]*certEntry),
+		aliases:  make(map[string]string),
+		pending:  make(map[string]CertificateInfo),
+		policy:   common.PinPolicyWarn,
+		filePath: certStoreFile,
+		logger:   logger,
+	}
+	store.load()
+
+	return store, nil
+}
+
+// load reads saved fingerprints from disk, migrating older store versions
+func (cs *CertificateStore) load() {
+	data, err := os.ReadFile(cs.filePath)
+	if err != nil {
+		// File doesn't exist yet - that's okay
+		return
+	}
+
+	var probe struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		cs.logger.Printf("Warning: Failed to load certificate store: %v", err)
+		return
+	}
+
+	var file certStoreFile
+	switch probe.Version {
+	case 0:
+		var legacy map[string]string
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			cs.logger.Printf("Warning: Failed to load certificate store: %v", err)
+			return
+		}
+		file = cs.migrateLegacy(legacy)
+
+	case 2:
+		var v2 certStoreFileV2
+		if err := json.Unmarshal(data, &v2); err != nil {
+			cs.logger.Printf("Warning: Failed to load certificate store: %v", err)
+			return
+		}
+		file = certStoreFile{
+			Version: certStoreVersion,
+			Pins:    entriesFromFingerprints(v2.Pins),
+			Aliases: v2.Aliases,
+		}
+
+	case certStoreVersion:
+		if err := json.Unmarshal(data, &file); err != nil {
+			cs.logger.Printf("Warning: Failed to load certificate store: %v", err)
+			return
+		}
+
+	default:
+		cs.logger.Printf("Warning: Unsupported certificate store version: %d", probe.Version)
+		return
+	}
+
+	cs.mu.Lock()
+	for key, entry := range file.Pins {
+		if entry != nil {
+			cs.entries[key] = entry
+		}
+	}
+	for alias, key := range file.Aliases {
+		cs.aliases[alias] = key
+	}
+	cs.mu.Unlock()
+
+	cs.logger.Printf("Loaded %d certificate fingerprints", len(file.Pins))
+
+	if probe.Version != certStoreVersion {
+		cs.logger.Printf("Migrated certificate store from version %d to %d", probe.Version, certStoreVersion)
+		cs.save()
+	}
+}
+
+// migrateLegacy converts a version 1 (ServerName-keyed) store
+// IP pins move to ip:443; hostname pins are kept under hostname:443 and are
+// re-keyed to the dialed address on the next successful connection
+func (cs *CertificateStore) migrateLegacy(legacy map[string]string) certStoreFile {
+	fingerprints := make(map[string]string, len(legacy))
+	for host, fp := range legacy {
+		if host == "" {
+			// Every camera reached by IP shared this slot - it can't be attributed to one
+			cs.logger.Printf("Warning: Dropping certificate pin with empty host during migration")
+			continue
+		}
+		fingerprints[pinKey(host)] = fp
+	}
+
+	return certStoreFile{
+		Version: certStoreVersion,
+		Pins:    entriesFromFingerprints(fingerprints),
+	}
+}
+
+// entriesFromFingerprints builds metadata-less entries from key -> fingerprint pins
+func entriesFromFingerprints(fingerprints map[string]string) map[string]*certEntry {
+	entries := make(map[string]*certEntry, len(fingerprints))
+	for key, fp := range fingerprints {
+		entries[key] = &certEntry{CertificateInfo: CertificateInfo{Fingerprint: fp}}
+	}
+	return entries
+}
+
+// save writes fingerprints to disk
+func (cs *CertificateStore) save() {
+	// Held across marshal and write so the newest snapshot is always written last
+	cs.saveMu.Lock()
+	defer cs.saveMu.Unlock()
+
+	cs.mu.RLock()
+	data, err := json.MarshalIndent(certStoreFile{
+		Version: certStoreVersion,
+		Pins:    cs.entries,
+		Aliases: cs.aliases,
+	}, "", "  ")
+	cs.mu.RUnlock()
+
+	if err != nil {
+		cs.logger.Printf("Error marshaling certificate store: %v", err)
+		return
+	}
+
+	// Write to a temp file first so a crash never leaves a truncated store
+	tmpPath := cs.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		cs.logger.Printf("Error saving certificate store: %v", err)
+		return
+	}
+	if err := os.Rename(tmpPath, cs.filePath); err != nil {
+		cs.logger.Printf("Error saving certificate store: %v", err)
+	}
+}
+
+// newCertificateInfo records the metadata of cert, first and last seen at now
+func newCertificateInfo(cert *x509.Certificate, now time.Time) CertificateInfo {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+
+	return CertificateInfo{
+		Fingerprint: calculateCertFingerprint(cert),
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		SANs:        sans,
+		NotBefore:   cert.NotBefore.UTC(),
+		NotAfter:    cert.NotAfter.UTC(),
+		FirstSeen:   now,
+		LastSeen:    now,
+	}
+}
+
+// expiryStatus returns the expiry state of info at now, or "" when its validity is unknown
+func expiryStatus(info CertificateInfo, now time.Time, within time.Duration) string {
+	switch {
+	case info.NotAfter.IsZero():
+		return ""
+	case now.After(info.NotAfter):
+		return CertExpiryExpired
+	case info.NotAfter.Sub(now) < within:
+		return CertExpiryExpiring
+	default:
+		return CertExpiryOK
+	}
+}
+
+// replace pins info for the entry, moving the current certificate into its history
+func (entry *certEntry) replace(info CertificateInfo) {
+	if entry.Fingerprint != "" {
+		entry.History = append([]CertificateInfo{entry.CertificateInfo}, entry.History...)
+		if len(entry.History) > maxCertHistory {
+			entry.History = entry.History[:maxCertHistory]
+		}
+	}
+	entry.CertificateInfo = info
+}
+
+// pinKey normalizes a host or host:port to the lower-case host:port form used as a
+// store key; the port defaults to 443
+func pinKey(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		return net.JoinHostPort(h, port)
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), "443")
+}
+
+// resolveLocked maps a host, host:port or alias to its store key (caller holds cs.mu)
+func (cs *CertificateStore) resolveLocked(host string) string {
+	key := pinKey(host)
+	if _, ok := cs.entries[key]; ok {
+		return key
+	}
+	if target, ok := cs.aliases[key]; ok {
+		return target
+	}
+	return key
+}
+
+// Lookup returns the pinned fingerprint for a connection to addr (the dialed ip:port)
+// requested as host (host:port from the URL). Falls back to the address host was last
+// seen at, then to a legacy pin keyed by host
+func (cs *CertificateStore) Lookup(addr, host string) (string, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	if entry, ok := cs.entries[pinKey(addr)]; ok && !entry.ChainValidated {
+		return entry.Fingerprint, true
+	}
+	hostKey := pinKey(host)
+	if target, ok := cs.aliases[hostKey]; ok {
+		if entry, ok := cs.entries[target]; ok && !entry.ChainValidated {
+			return entry.Fingerprint, true
+		}
+	}
+	if entry, ok := cs.entries[hostKey]; ok && !entry.ChainValidated {
+		return entry.Fingerprint, true
+	}
+	return "", false
+}
+
+// Pin records cert for addr (new pin or matching certificate) and records host as an
+// alias when it differs. Fills in metadata missing from migrated pins and updates last seen
+// Only writes to disk when something changed
+func (cs *CertificateStore) Pin(addr, host string, cert *x509.Certificate) {
+	cs.record(addr, host, cert, true)
+}
+
+// Observe records the metadata of a chain-validated certificate for addr without pinning it,
+// so expiry reporting covers cameras in chain mode. An existing pin for addr is left in place
+func (cs *CertificateStore) Observe(addr, host string, cert *x509.Certificate) {
+	cs.record(addr, host, cert, false)
+}
+
+// record implements Pin (pin) and Observe (!pin)
+func (cs *CertificateStore) record(addr, host string, cert *x509.Certificate, pin bool) {
+	addrKey, hostKey := pinKey(addr), pinKey(host)
+	now := time.Now().UTC()
+	info := newCertificateInfo(cert, now)
+
+	cs.mu.Lock()
+	changed := false
+
+	entry, ok := cs.entries[addrKey]
+	if !ok && hostKey != addrKey {
+		if legacy, isLegacy := cs.entries[hostKey]; isLegacy {
+			// Migrated hostname pin - now keyed by address
+			entry, ok = legacy, true
+			delete(cs.entries, hostKey)
+			cs.entries[addrKey] = entry
+			changed = true
+		}
+	}
+
+	switch {
+	case !ok:
+		entry = &certEntry{CertificateInfo: info, ChainValidated: !pin}
+		cs.entries[addrKey] = entry
+		changed = true
+
+	case entry.Fingerprint != info.Fingerprint:
+		if !pin && !entry.ChainValidated {
+			// Keep the pin in case the camera goes back to pinning mode
+			break
+		}
+		entry.replace(info)
+		changed = true
+
+	default:
+		if entry.NotAfter.IsZero() {
+			// Imported or migrated pin seen for the first time
+			firstSeen := entry.FirstSeen
+			entry.CertificateInfo = info
+			if !firstSeen.IsZero() {
+				entry.FirstSeen = firstSeen
+			}
+			changed = true
+		}
+		if now.Sub(entry.LastSeen) > lastSeenSaveInterval {
+			changed = true
+		}
+		entry.LastSeen = now
+	}
+
+	if pin && entry.ChainValidated {
+		entry.ChainValidated = false
+		changed = true
+	}
+
+	if hostKey != addrKey && cs.aliases[hostKey] != addrKey {
+		cs.aliases[hostKey] = addrKey
+		changed = true
+	}
+	cs.mu.Unlock()
+
+	if changed {
+		cs.save()
+	}
+}
+
+// PinnedCertificate describes the pin for one camera address
+type PinnedCertificate struct {
+	Host    string   `json:"host"` // ip:port
+	Aliases []string `json:"aliases,omitempty"`
+	CertificateInfo
+	ExpiryStatus   string            `json:"expiryStatus,omitempty"`   // ok, expiring or expired
+	ChainValidated bool              `json:"chainValidated,omitempty"` // Tracked for expiry only, not pinned
+	History        []CertificateInfo `json:"history,omitempty"`
+	Pending        *CertificateInfo  `json:"pending,omitempty"` // Last rejected certificate, if any
+}
+
+// List returns all pinned addresses (and addresses with a rejected certificate), sorted by host
+func (cs *CertificateStore) List() []PinnedCertificate {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	keys := make(map[string]bool, len(cs.entries)+len(cs.pending))
+	for key := range cs.entries {
+		keys[key] = true
+	}
+	for key := range cs.pending {
+		keys[key] = true
+	}
+
+	now := time.Now()
+	pins := make([]PinnedCertificate, 0, len(keys))
+	for key := range keys {
+		pins = append(pins, cs.pinLocked(key, now))
+	}
+	sort.Slice(pins, func(i, j int) bool {
+		return pins[i].Host < pins[j].Host
+	})
+	return pins
+}
+
+// Expiring returns pinned certificates that have expired or expire within the given window
+func (cs *CertificateStore) Expiring(within time.Duration) []PinnedCertificate {
+	now := time.Now()
+
+	var expiring []PinnedCertificate
+	for _, pin := range cs.List() {
+		if pin.Fingerprint == "" {
+			continue
+		}
+		if status := expiryStatus(pin.CertificateInfo, now, within); status == CertExpiryExpiring || status == CertExpiryExpired {
+			pin.ExpiryStatus = status
+			expiring = append(expiring, pin)
+		}
+	}
+	return expiring
+}
+
+// Get returns the pin for host (ip, ip:port or a hostname alias)
+func (cs *CertificateStore) Get(host string) (PinnedCertificate, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	key := cs.resolveLocked(host)
+	_, pinned := cs.entries[key]
+	_, pending := cs.pending[key]
+	if !pinned && !pending {
+		return PinnedCertificate{}, false
+	}
+	return cs.pinLocked(key, time.Now()), true
+}
+
+// pinLocked builds the PinnedCertificate for key (caller holds cs.mu)
+func (cs *CertificateStore) pinLocked(key string, now time.Time) PinnedCertificate {
+	var aliases []string
+	for alias, target := range cs.aliases {
+		if target == key {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+
+	pin := PinnedCertificate{
+		Host:    key,
+		Aliases: aliases,
+	}
+	if entry, ok := cs.entries[key]; ok {
+		pin.CertificateInfo = entry.CertificateInfo
+		pin.ExpiryStatus = expiryStatus(entry.CertificateInfo, now, certExpiryWarning)
+		pin.ChainValidated = entry.ChainValidated
+		pin.History = entry.History
+	}
+	if pending, ok := cs.pending[key]; ok {
+		pin.Pending = &pending
+	}
+	return pin
+}
+
+// Delete removes the pin for host and its aliases so its next certificate is treated as new
+func (cs *CertificateStore) Delete(host string) error {
+	cs.mu.Lock()
+	key := cs.resolveLocked(host)
+	_, pinned := cs.entries[key]
+	_, pending := cs.pending[key]
+	if !pinned && !pending {
+		cs.mu.Unlock()
+		return fmt.Errorf("no pinned certificate for %s", host)
+	}
+	delete(cs.entries, key)
+	delete(cs.pending, key)
+	for alias, target := range cs.aliases {
+		if target == key {
+			delete(cs.aliases, alias)
+		}
+	}
+	cs.mu.Unlock()
+	cs.save()
+	return nil
+}
+
+// Export returns a copy of all pinned fingerprints and aliases
+func (cs *CertificateStore) Export() (map[string]string, map[string]string) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	fingerprints := make(map[string]string, len(cs.entries))
+	for key, entry := range cs.entries {
+		if !entry.ChainValidated {
+			fingerprints[key] = entry.Fingerprint
+		}
+	}
+	aliases := make(map[string]string, len(cs.aliases))
+	for alias, key := range cs.aliases {
+		aliases[alias] = key
+	}
+	return fingerprints, aliases
+}
+
+// Import adds pins (host -> fingerprint) and aliases (hostname -> host), overwriting
+// existing entries for the same keys. Hosts without a port default to 443
+// With replace, everything not imported is dropped (an empty replace is rejected rather
+// than wiping the store). Nothing is changed if any entry is invalid
+func (cs *CertificateStore) Import(fingerprints, aliases map[string]string, replace bool) (int, error) {
+	if replace && len(fingerprints) == 0 {
+		// Most likely a malformed bundle; use DELETE /certs to remove pins
+		return 0, fmt.Errorf("replace requires at least one certificate")
+	}
+	normalized := make(map[string]string, len(fingerprints))
+	for host, fp := range fingerprints {
+		if host == "" {
+			return 0, fmt.Errorf("host is required")
+		}
+		n, err := normalizeFingerprint(fp)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", host, err)
+		}
+		normalized[pinKey(host)] = n
+	}
+	normalizedAliases := make(map[string]string, len(aliases))
+	for alias, host := range aliases {
+		if alias == "" || host == "" {
+			return 0, fmt.Errorf("alias and host are required")
+		}
+		normalizedAliases[pinKey(alias)] = pinKey(host)
+	}
+
+	cs.mu.Lock()
+	previous := cs.entries
+	if replace {
+		cs.entries = make(map[string]*certEntry, len(normalized))
+		cs.aliases = make(map[string]string, len(normalizedAliases))
+	}
+	for key, fp := range normalized {
+		entry, ok := previous[key]
+		switch {
+		case !ok:
+			entry = &certEntry{CertificateInfo: CertificateInfo{Fingerprint: fp}}
+		case entry.Fingerprint != fp:
+			entry.replace(CertificateInfo{Fingerprint: fp})
+		}
+		entry.ChainValidated = false
+		cs.entries[key] = entry
+		delete(cs.pending, key)
+	}
+	for alias, key := range normalizedAliases {
+		cs.aliases[alias] = key
+	}
+	cs.mu.Unlock()
+	cs.save()
+
+	return len(normalized), nil
+}
+
+// Policy returns the active pin policy
+func (cs *CertificateStore) Policy() common.PinPolicy {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.policy
+}
+
+// SetPolicy changes the active pin policy
+func (cs *CertificateStore) SetPolicy(policy common.PinPolicy) {
+	cs.mu.Lock()
+	cs.policy = policy
+	cs.mu.Unlock()
+}
+
+// setPending remembers a certificate that did not match the pin for addr
+func (cs *CertificateStore) setPending(addr string, cert *x509.Certificate) {
+	now := time.Now().UTC()
+	key := pinKey(addr)
+
+	cs.mu.Lock()
+	info := newCertificateInfo(cert, now)
+	if previous, ok := cs.pending[key]; ok && previous.Fingerprint == info.Fingerprint {
+		info.FirstSeen = previous.FirstSeen
+	}
+	cs.pending[key] = info
+	cs.mu.Unlock()
+}
+
+// AcceptFingerprint pins fingerprint for host (ip, ip:port or a hostname alias),
+// replacing any existing pin. An empty fingerprint accepts the certificate last
+// rejected for host, so a rotated camera certificate can be approved without
+// copying the hash
+func (cs *CertificateStore) AcceptFingerprint(host, fingerprint string) (string, string, error) {
+	if host == "" {
+		return "", "", fmt.Errorf("host is required")
+	}
+
+	cs.mu.Lock()
+	key := cs.resolveLocked(host)
+	pending, hasPending := cs.pending[key]
+
+	var info CertificateInfo
+	if fingerprint == "" {
+		if !hasPending {
+			cs.mu.Unlock()
+			return "", "", fmt.Errorf("no rejected certificate to accept for %s", host)
+		}
+		info = pending
+	} else {
+		normalized, err := normalizeFingerprint(fingerprint)
+		if err != nil {
+			cs.mu.Unlock()
+			return "", "", err
+		}
+		info = CertificateInfo{Fingerprint: normalized}
+		if hasPending && pending.Fingerprint == normalized {
+			info = pending
+		}
+	}
+
+	if entry, ok := cs.entries[key]; ok {
+		if entry.Fingerprint != info.Fingerprint {
+			entry.replace(info)
+		}
+		entry.ChainValidated = false
+	} else {
+		cs.entries[key] = &certEntry{CertificateInfo: info}
+	}
+	delete(cs.pending, key)
+	cs.mu.Unlock()
+	cs.save()
+
+	cs.logger.Printf("Accepted certificate for %s", key)
+	cs.logger.Printf("   Fingerprint: %s", info.Fingerprint)
+	return key, info.Fingerprint, nil
+}
+
+// normalizeFingerprint accepts hex SHA256 fingerprints with or without colons
+func normalizeFingerprint(fingerprint string) (string, error) {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+	if decoded, err := hex.DecodeString(normalized); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA256 fingerprint: %s", fingerprint)
+	}
+	return normalized, nil
+}
+
+// calculateCertFingerprint returns SHA256 fingerprint of certificate
+func calculateCertFingerprint(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestCertificateStoreConcurrentSaves(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	logger := log.New(io.Discard, "", 0)
+	store, err := NewCertificateStore(logger)
+	if err != nil {
+		t.Fatalf("NewCertificateStore: %v", err)
+	}
+
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+	cert := server.Certificate()
+
+	const hosts = 64
+	var wg sync.WaitGroup
+	for i := 0; i < hosts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			addr := fmt.Sprintf("192.168.1.%d:443", i)
+			if i%2 == 0 {
+				store.Pin(addr, addr, cert)
+			} else {
+				store.Observe(addr, addr, cert)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if _, err := os.Stat(store.filePath + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temp file left behind: %v", err)
+	}
+
+	// The file must hold the final state, not an older snapshot written last
+	reloaded, err := NewCertificateStore(logger)
+	if err != nil {
+		t.Fatalf("NewCertificateStore: %v", err)
+	}
+	if got := len(reloaded.List()); got != hosts {
+		t.Errorf("reloaded %d certificates, want %d", got, hosts)
+	}
+	for i := 0; i < hosts; i += 2 {
+		addr := fmt.Sprintf("192.168.1.%d:443", i)
+		if _, ok := reloaded.Lookup(addr, addr); !ok {
+			t.Errorf("pin for %s lost", addr)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// credentialPayload is the body accepted by the credential endpoints
+type credentialPayload struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// AllowedHosts are the camera IPs, CIDR subnets and hostnames the credential may be used for
+	AllowedHosts []string `json:"allowedHosts"`
+}
+
+// handleCredentials lists (GET), adds (POST) and deletes (DELETE ?id=) vault credentials
+// Passwords are never returned; usernames are sanitized
+func (ps *ProxyServer) handleCredentials(w http.ResponseWriter, r *http.Request) {
+	if !ps.requireTrustedOrigin(w, r) {
+		return
+	}
+	ps.setCORSHeaders(w, r)
+
+	switch r.Method {
+	case "OPTIONS":
+		w.WriteHeader(http.StatusOK)
+
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"credentials": ps.vault.List(),
+		})
+
+	case "POST":
+		var payload credentialPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			ps.logger.Printf("Failed to decode credentials request: %v", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		summary, err := ps.vault.Add(payload.Name, payload.Username, payload.Password, payload.AllowedHosts)
+		if err != nil {
+			ps.logger.Printf("Failed to add credential: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ps.logger.Printf("Added credential %s (user: %s)", summary.ID, summary.Username)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(summary)
+
+	case "DELETE":
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "id required", http.StatusBadRequest)
+			return
+		}
+
+		if err := ps.vault.Delete(id); err != nil {
+			ps.logger.Printf("Failed to delete credential %s: %v", id, err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		ps.logger.Printf("Deleted credential %s", id)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRotateCredential replaces the password (and optionally username) of a vault credential
+func (ps *ProxyServer) handleRotateCredential(w http.ResponseWriter, r *http.Request) {
+	if !ps.requireTrustedOrigin(w, r) {
+		return
+	}
+	ps.setCORSHeaders(w, r)
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload credentialPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		ps.logger.Printf("Failed to decode credential rotate request: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	summary, err := ps.vault.Rotate(payload.ID, payload.Username, payload.Password, payload.AllowedHosts)
+	if err != nil {
+		ps.logger.Printf("Failed to rotate credential %s: %v", payload.ID, err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	ps.logger.Printf("Rotated credential %s", summary.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
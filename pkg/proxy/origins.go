@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"mime"
+	"net/http"
+)
+
+// defaultTrustedOrigins may use vault credentials and change the certificate store
+// Same list as the standalone proxy server; more can be added with allowedOrigins in
+// connector-config.json (e.g. a locally installed extension ID)
+var defaultTrustedOrigins = []string{
+	"http://localhost:5173",                               // Local dev server
+	"http://localhost:3000",                               // Alternative local dev
+	"https://anava-ai.web.app",                            // Production web app
+	"http://127.0.0.1:5173",                               // Localhost IP variant
+	"http://127.0.0.1:3000",                               // Localhost IP variant
+	"chrome-extension://ojhdgnojgelfiejpgipjddfddgefdpfa", // Extension ID (from install script)
+}
+
+// newTrustedOrigins builds the trusted origin set from the defaults and configured extras
+func newTrustedOrigins(extra []string) map[string]bool {
+	origins := make(map[string]bool, len(defaultTrustedOrigins)+len(extra))
+	for _, origin := range defaultTrustedOrigins {
+		origins[origin] = true
+	}
+	for _, origin := range extra {
+		if origin != "" {
+			origins[origin] = true
+		}
+	}
+	return origins
+}
+
+// isTrustedOrigin reports whether a request may use vault credentials or change pins
+// Requests without an Origin header come from local tools (native host, curl), not web pages
+func (ps *ProxyServer) isTrustedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || ps.trustedOrigins[origin]
+}
+
+// requireTrustedOrigin rejects requests from untrusted origins with 403
+// Any page can reach the localhost proxy, so privileged endpoints must not rely on CORS alone
+func (ps *ProxyServer) requireTrustedOrigin(w http.ResponseWriter, r *http.Request) bool {
+	if ps.isTrustedOrigin(r) {
+		return true
+	}
+	ps.logger.Printf("SECURITY: Blocked %s %s from untrusted origin: %s", r.Method, r.URL.Path, r.Header.Get("Origin"))
+	http.Error(w, "Forbidden: Origin not allowed", http.StatusForbidden)
+	return false
+}
+
+// requireJSON rejects request bodies that aren't sent as application/json with 415
+// Unlike text/plain, a JSON body makes browsers send a CORS preflight first
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType == "application/json" {
+		return true
+	}
+	http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+	return false
+}
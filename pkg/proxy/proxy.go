@@ -2,129 +2,29 @@ package proxy
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"context"
 	"crypto/tls"
-	"crypto/x509"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
-	"sync"
-	"time"
 
 	"anava-camera-extension/pkg/common"
 )
 
-// CertificateStore manages certificate fingerprints for known cameras
-type CertificateStore struct {
-	mu           sync.RWMutex
-	fingerprints map[string]string // host -> SHA256 fingerprint
-	filePath     string
-	logger       *log.Logger
-}
-
-// NewCertificateStore creates a new certificate store
-func NewCertificateStore(logger *log.Logger) (*CertificateStore, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
-	}
-
-	// macOS: ~/Library/Application Support/Anava/
-	// Linux: ~/.local/share/anava/
-	var certStoreDir string
-	switch {
-	case fileExists(filepath.Join(homeDir, "Library")): // macOS
-		certStoreDir = filepath.Join(homeDir, "Library", "Application Support", "Anava")
-	default: // Linux/Windows
-		certStoreDir = filepath.Join(homeDir, ".local", "share", "anava")
-	}
-
-	if err := os.MkdirAll(certStoreDir, 0700); err != nil {
-		return nil, fmt.Errorf("failed to create cert store directory: %w", err)
-	}
-
-	certStoreFile := filepath.Join(certStoreDir, "certificate-fingerprints.json")
-
-	store := &CertificateStore{
-		fingerprints: make(map[string]string),
-		filePath:     certStoreFile,
-		logger:       logger,
-	}
-	store.load()
-
-	return store, nil
-}
-
-// load reads saved fingerprints from disk
-func (cs *CertificateStore) load() {
-	data, err := os.ReadFile(cs.filePath)
-	if err != nil {
-		// File doesn't exist yet - that's okay
-		return
-	}
-
-	var fingerprints map[string]string
-	if err := json.Unmarshal(data, &fingerprints); err != nil {
-		cs.logger.Printf("Warning: Failed to load certificate store: %v", err)
-		return
-	}
-
-	cs.mu.Lock()
-	cs.fingerprints = fingerprints
-	cs.mu.Unlock()
-
-	cs.logger.Printf("Loaded %d certificate fingerprints", len(fingerprints))
-}
-
-// save writes fingerprints to disk
-func (cs *CertificateStore) save() {
-	cs.mu.RLock()
-	data, err := json.MarshalIndent(cs.fingerprints, "", "  ")
-	cs.mu.RUnlock()
-
-	if err != nil {
-		cs.logger.Printf("Error marshaling certificate store: %v", err)
-		return
-	}
-
-	if err := os.WriteFile(cs.filePath, data, 0600); err != nil {
-		cs.logger.Printf("Error saving certificate store: %v", err)
-	}
-}
-
-// GetFingerprint returns the stored fingerprint for a host
-func (cs *CertificateStore) GetFingerprint(host string) (string, bool) {
-	cs.mu.RLock()
-	defer cs.mu.RUnlock()
-	fp, ok := cs.fingerprints[host]
-	return fp, ok
-}
-
-// SetFingerprint stores a fingerprint for a host
-func (cs *CertificateStore) SetFingerprint(host, fingerprint string) {
-	cs.mu.Lock()
-	cs.fingerprints[host] = fingerprint
-	cs.mu.Unlock()
-	cs.save()
-}
-
-// calculateCertFingerprint returns SHA256 fingerprint of certificate
-func calculateCertFingerprint(cert *x509.Certificate) string {
-	hash := sha256.Sum256(cert.Raw)
-	return hex.EncodeToString(hash[:])
-}
-
 // ProxyServer represents the proxy service
 type ProxyServer struct {
-	logger    *log.Logger
-	certStore *CertificateStore
-	client    *http.Client
+	logger      *log.Logger
+	certStore   *CertificateStore
+	client      *http.Client
+	digestCache *common.DigestCache
+	authSchemes *authSchemeCache
+	vault       *common.CredentialVault
+	// trustedOrigins may use vault credentials and change the certificate store
+	trustedOrigins map[string]bool
 }
 
 // NewProxyServer creates a new proxy server instance
@@ -134,49 +34,107 @@ func NewProxyServer(logger *log.Logger) (*ProxyServer, error) {
 		return nil, fmt.Errorf("failed to create certificate store: %w", err)
 	}
 
+	vault, err := common.NewCredentialVault()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open credential vault: %w", err)
+	}
+	if err := vault.Unavailable(); err != nil {
+		logger.Printf("Warning: %v", err)
+	}
+
 	ps := &ProxyServer{
-		logger:    logger,
-		certStore: certStore,
+		logger:         logger,
+		certStore:      certStore,
+		digestCache:    common.NewDigestCache(),
+		authSchemes:    newAuthSchemeCache(),
+		vault:          vault,
+		trustedOrigins: newTrustedOrigins(nil),
+	}
+
+	// Certificate pin policy and extra trusted origins come from connector-config.json (default: warn)
+	if configStorage, err := common.NewConfigStorage(); err == nil {
+		if config, err := configStorage.Load(); err == nil {
+			policy, err := common.ParsePinPolicy(config.CertPinPolicy)
+			if err != nil {
+				logger.Printf("Warning: %v, using %s", err, common.PinPolicyWarn)
+				policy = common.PinPolicyWarn
+			}
+			certStore.SetPolicy(policy)
+			ps.trustedOrigins = newTrustedOrigins(config.AllowedOrigins)
+		}
+	}
+	logger.Printf("Certificate pin policy: %s", certStore.Policy())
+
+	// Chain validation vs pinning per camera comes from camera-tls.json (default: pin all)
+	cameraTLS, err := common.LoadCameraTLS()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load camera TLS config: %w", err)
 	}
+	logger.Printf("Camera TLS: %s", cameraTLS)
 
 	// Create HTTP client with certificate validation
-	ps.client = common.CreateHTTPClient(30*time.Second, ps.verifyCertificate)
+	ps.client = common.CreateHTTPClient(common.DefaultRequestTimeout, cameraTLS, ps.verifyCertificate)
 
 	return ps, nil
 }
 
 // verifyCertificate validates TLS certificate fingerprints
-func (ps *ProxyServer) verifyCertificate(cs tls.ConnectionState) error {
+// addr is the dialed ip:port (the pin key); host is the host:port from the request URL
+func (ps *ProxyServer) verifyCertificate(addr, host string, cs tls.ConnectionState) error {
 	if len(cs.PeerCertificates) == 0 {
 		return fmt.Errorf("no peer certificates")
 	}
 
 	// Get the leaf certificate (server's cert)
 	cert := cs.PeerCertificates[0]
-	host := cs.ServerName
 	currentFingerprint := calculateCertFingerprint(cert)
 
-	// Check if we've seen this host before
-	if storedFingerprint, exists := ps.certStore.GetFingerprint(host); exists {
-		// We've seen this host - verify fingerprint matches
-		if storedFingerprint != currentFingerprint {
-			// SECURITY ALERT: Certificate changed!
-			ps.logger.Printf("🚨 SECURITY ALERT: Certificate changed for %s", host)
-			ps.logger.Printf("   Stored fingerprint: %s", storedFingerprint)
-			ps.logger.Printf("   Current fingerprint: %s", currentFingerprint)
-			ps.logger.Printf("   This could indicate a Man-in-the-Middle attack!")
-
-			// For now, we'll log but allow (to prevent breaking deployments)
-			// In production, consider returning an error here
-			// return fmt.Errorf("certificate fingerprint mismatch for %s", host)
-		} else {
-			ps.logger.Printf("✓ Certificate validated for %s (fingerprint matches)", host)
-		}
-	} else {
+	label := addr
+	if pinKey(host) != pinKey(addr) {
+		label = fmt.Sprintf("%s (%s)", addr, host)
+	}
+
+	if len(cs.VerifiedChains) > 0 {
+		// Chain-validated (TrustModeChain): track the certificate for expiry, don't pin it
+		ps.certStore.Observe(addr, host, cert)
+		return nil
+	}
+
+	policy := ps.certStore.Policy()
+
+	storedFingerprint, exists := ps.certStore.Lookup(addr, host)
+	switch {
+	case !exists && policy == common.PinPolicyPreApproved:
+		ps.logger.Printf("🚫 Rejecting certificate for %s: not pre-approved", label)
+		ps.logger.Printf("   Fingerprint: %s", currentFingerprint)
+		ps.certStore.setPending(addr, cert)
+		return fmt.Errorf("%w: %s is not pre-approved", common.ErrCertificateMismatch, label)
+
+	case !exists:
 		// First time seeing this host - store fingerprint
-		ps.logger.Printf("📌 Pinning certificate for new host: %s", host)
+		ps.logger.Printf("📌 Pinning certificate for new host: %s", label)
 		ps.logger.Printf("   Fingerprint: %s", currentFingerprint)
-		ps.certStore.SetFingerprint(host, currentFingerprint)
+		ps.certStore.Pin(addr, host, cert)
+
+	case storedFingerprint != currentFingerprint:
+		// SECURITY ALERT: Certificate changed!
+		ps.logger.Printf("🚨 SECURITY ALERT: Certificate changed for %s", label)
+		ps.logger.Printf("   Stored fingerprint: %s", storedFingerprint)
+		ps.logger.Printf("   Current fingerprint: %s", currentFingerprint)
+		ps.logger.Printf("   This could indicate a Man-in-the-Middle attack!")
+		ps.certStore.setPending(addr, cert)
+
+		if policy == common.PinPolicyWarn {
+			// Warn-only: log but allow (to prevent breaking deployments)
+			return nil
+		}
+		ps.logger.Printf("   Blocked by %s policy - accept the new fingerprint if the certificate was rotated", policy)
+		return fmt.Errorf("%w for %s", common.ErrCertificateMismatch, label)
+
+	default:
+		ps.logger.Printf("✓ Certificate validated for %s (fingerprint matches)", label)
+		// Re-keys migrated hostname pins and keeps aliases current
+		ps.certStore.Pin(addr, host, cert)
 	}
 
 	return nil
@@ -188,6 +146,13 @@ func (ps *ProxyServer) Run(port string) error {
 	http.HandleFunc("/health", ps.handleHealth)
 	http.HandleFunc("/upload-acap", ps.handleUploadAcap)
 	http.HandleFunc("/upload-license", ps.handleUploadLicense)
+	http.HandleFunc("/credentials", ps.handleCredentials)
+	http.HandleFunc("/credentials/rotate", ps.handleRotateCredential)
+	http.HandleFunc("/certs", ps.handleCertificates)
+	http.HandleFunc("/certs/accept", ps.handleAcceptCertificate)
+	http.HandleFunc("/certs/import", ps.handleImportCertificates)
+	http.HandleFunc("/certs/export", ps.handleExportCertificates)
+	http.HandleFunc("/certs/expiring", ps.handleExpiringCertificates)
 
 	addr := "127.0.0.1:" + port
 
@@ -199,8 +164,24 @@ func (ps *ProxyServer) Run(port string) error {
 }
 
 func (ps *ProxyServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	// Surface camera certificates that need attention (details at /certs/expiring)
+	expired, expiring := 0, 0
+	for _, pin := range ps.certStore.Expiring(certExpiryWarning) {
+		if pin.ExpiryStatus == CertExpiryExpired {
+			expired++
+		} else {
+			expiring++
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"certificates": map[string]int{
+			"expired":  expired,
+			"expiring": expiring,
+		},
+	})
 }
 
 func (ps *ProxyServer) handleProxyRequest(w http.ResponseWriter, r *http.Request) {
@@ -219,7 +200,19 @@ func (ps *ProxyServer) handleProxyRequest(w http.ResponseWriter, r *http.Request
 	var req common.ProxyRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		ps.logger.Printf("Failed to decode request: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		ps.writeProxyError(w, r, common.NewProxyError(common.ErrCodeBadRequest, fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	// Vault credentials are only for trusted origins - any page can reach this proxy
+	if req.CredentialID != "" && !ps.requireTrustedOrigin(w, r) {
+		return
+	}
+
+	// Fill in username/password from the vault when a credential ID is given
+	if err := ps.vault.Resolve(&req); err != nil {
+		ps.logger.Printf("Failed to resolve credential: %v", err)
+		ps.writeProxyError(w, r, common.NewProxyError(common.ErrCodeBadRequest, err))
 		return
 	}
 
@@ -227,14 +220,10 @@ func (ps *ProxyServer) handleProxyRequest(w http.ResponseWriter, r *http.Request
 	ps.logger.Printf("Proxying request: %s %s (user: %s)", req.Method, req.URL, common.SanitizeCredential(req.Username))
 
 	// Make request to camera (follows Electron authentication pattern)
-	resp, err := ps.makeCameraRequest(&req)
+	resp, err := ps.makeCameraRequest(r.Context(), &req)
 	if err != nil {
 		ps.logger.Printf("Camera request failed: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(common.ProxyResponse{
-			Error: fmt.Sprintf("Request failed: %v", err),
-		})
+		ps.writeProxyError(w, r, err)
 		return
 	}
 
@@ -245,12 +234,74 @@ func (ps *ProxyServer) handleProxyRequest(w http.ResponseWriter, r *http.Request
 	json.NewEncoder(w).Encode(resp)
 }
 
-func (ps *ProxyServer) makeCameraRequest(req *common.ProxyRequest) (common.ProxyResponse, error) {
+// writeProxyError reports err as a ProxyResponse with its error code and matching HTTP status
+func (ps *ProxyServer) writeProxyError(w http.ResponseWriter, r *http.Request, err error) {
+	code := common.ClassifyError(err)
+
+	ps.setCORSHeaders(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code.HTTPStatus())
+	json.NewEncoder(w).Encode(common.ProxyResponse{
+		Error:     fmt.Sprintf("Request failed: %v", err),
+		ErrorCode: code,
+	})
+}
+
+// makeCameraRequest proxies req to the camera, stopping as soon as ctx is cancelled
+func (ps *ProxyServer) makeCameraRequest(ctx context.Context, req *common.ProxyRequest) (common.ProxyResponse, error) {
+	// Fast path: go straight to the scheme that last worked for this camera
+	if scheme, ok := ps.authSchemes.Get(req.URL); ok && (scheme != authSchemeBasic || basicAuthAllowed(req)) {
+		ps.logger.Printf("Using remembered auth scheme for %s: %s", cameraEndpoint(req.URL), scheme)
+
+		resp, err := ps.tryAuthScheme(ctx, scheme, req)
+		if err == nil && resp.Status != 401 {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return common.ProxyResponse{}, ctx.Err()
+		}
+
+		if err != nil && common.ClassifyError(err) == common.ErrCodeCertMismatch {
+			// Pinning rejected the camera - another auth scheme won't change that
+			return common.ProxyResponse{}, err
+		}
+
+		ps.authSchemes.Forget(req.URL)
+		if err != nil && (common.IsTimeoutError(err) || common.IsConnectionRefusedError(err)) {
+			ps.logger.Printf("Device not responding (timeout/refused) - not a camera")
+			return common.ProxyResponse{}, fmt.Errorf("device not responding: %w", err)
+		}
+		ps.logger.Printf("Remembered auth scheme %s failed, falling back to full sequence", scheme)
+	}
+
+	return ps.negotiateCameraAuth(ctx, req)
+}
+
+// basicAuthAllowed reports whether req's credentials may be sent as Basic auth
+// Vault credentials are only sent as Basic over HTTPS; plain HTTP gets Digest only
+func basicAuthAllowed(req *common.ProxyRequest) bool {
+	return req.CredentialID == "" || strings.HasPrefix(strings.ToLower(req.URL), "https://")
+}
+
+// tryAuthScheme makes a single request using the given auth scheme
+func (ps *ProxyServer) tryAuthScheme(ctx context.Context, scheme authScheme, req *common.ProxyRequest) (common.ProxyResponse, error) {
+	switch scheme {
+	case authSchemeBasic:
+		return common.TryBasicAuth(ctx, ps.client, req)
+	case authSchemeDigest:
+		return common.TryDigestAuth(ctx, ps.client, ps.digestCache, req)
+	default:
+		return common.TryNoAuthRequest(ctx, ps.client, req)
+	}
+}
+
+// negotiateCameraAuth runs the full Electron auth sequence and remembers the winning scheme
+func (ps *ProxyServer) negotiateCameraAuth(ctx context.Context, req *common.ProxyRequest) (common.ProxyResponse, error) {
 	// CRITICAL: Follow Electron pattern exactly
 	// Step 1: Try ONE unauthenticated request first (3 second timeout)
 	ps.logger.Println("Step 1: Testing connection without authentication")
 
-	resp, err := common.TryUnauthenticatedRequest(ps.client, req)
+	resp, err := common.TryUnauthenticatedRequest(ctx, ps.client, req)
 
 	// On timeout/connection refused, return immediately (not a camera)
 	if err != nil {
@@ -264,6 +315,7 @@ func (ps *ProxyServer) makeCameraRequest(req *common.ProxyRequest) (common.Proxy
 	// If 200, no auth needed - success!
 	if resp.Status == 200 {
 		ps.logger.Println("Success: No authentication required")
+		ps.authSchemes.Set(req.URL, authSchemeNone)
 		return resp, nil
 	}
 
@@ -277,31 +329,36 @@ func (ps *ProxyServer) makeCameraRequest(req *common.ProxyRequest) (common.Proxy
 	ps.logger.Println("Step 2: 401 received, trying authentication")
 
 	// Determine protocol from URL
-	isHTTPS := strings.HasPrefix(req.URL, "https://")
-
-	if isHTTPS {
-		// HTTPS: Try Basic first, then Digest
-		ps.logger.Println("HTTPS detected: Trying Basic Auth first")
-		resp, err := common.TryBasicAuth(ps.client, req)
-		if err == nil && resp.Status == 200 {
-			ps.logger.Println("Basic Auth succeeded")
-			return resp, nil
-		}
+	// HTTPS: Try Basic first, then Digest
+	// HTTP: Try Digest first, then Basic
+	first, second := authSchemeDigest, authSchemeBasic
+	if strings.HasPrefix(req.URL, "https://") {
+		first, second = authSchemeBasic, authSchemeDigest
+	}
+
+	ps.logger.Printf("Trying %s auth first", first)
+	resp, err = ps.tryAuthScheme(ctx, first, req)
+	if err == nil && resp.Status == 200 {
+		ps.logger.Printf("%s auth succeeded", first)
+		ps.authSchemes.Set(req.URL, first)
+		return resp, nil
+	}
+	if ctx.Err() != nil {
+		return common.ProxyResponse{}, ctx.Err()
+	}
 
-		ps.logger.Println("Basic Auth failed, trying Digest Auth")
-		return common.TryDigestAuth(ps.client, req)
-	} else {
-		// HTTP: Try Digest first, then Basic
-		ps.logger.Println("HTTP detected: Trying Digest Auth first")
-		resp, err := common.TryDigestAuth(ps.client, req)
-		if err == nil && resp.Status == 200 {
-			ps.logger.Println("Digest Auth succeeded")
-			return resp, nil
-		}
+	if !basicAuthAllowed(req) {
+		// SECURITY: Never send vault credentials in the clear
+		ps.logger.Println("Vault credential over plain HTTP: not falling back to Basic auth")
+		return resp, err
+	}
 
-		ps.logger.Println("Digest Auth failed, trying Basic Auth")
-		return common.TryBasicAuth(ps.client, req)
+	ps.logger.Printf("%s auth failed, trying %s auth", first, second)
+	resp, err = ps.tryAuthScheme(ctx, second, req)
+	if err == nil && resp.Status == 200 {
+		ps.authSchemes.Set(req.URL, second)
 	}
+	return resp, err
 }
 
 func (ps *ProxyServer) handleUploadAcap(w http.ResponseWriter, r *http.Request) {
@@ -318,10 +375,11 @@ func (ps *ProxyServer) handleUploadAcap(w http.ResponseWriter, r *http.Request)
 	}
 
 	var payload struct {
-		URL      string `json:"url"`
-		Username string `json:"username"`
-		Password string `json:"password"`
-		AcapURL  string `json:"acapUrl"`
+		URL          string `json:"url"`
+		Username     string `json:"username"`
+		Password     string `json:"password"`
+		CredentialID string `json:"credentialId"`
+		AcapURL      string `json:"acapUrl"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -330,10 +388,33 @@ func (ps *ProxyServer) handleUploadAcap(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	proxyReq := &common.ProxyRequest{
+		URL:          payload.URL,
+		Method:       "POST",
+		Username:     payload.Username,
+		Password:     payload.Password,
+		CredentialID: payload.CredentialID,
+	}
+	if proxyReq.CredentialID != "" && !ps.requireTrustedOrigin(w, r) {
+		return
+	}
+	if err := ps.vault.Resolve(proxyReq); err != nil {
+		ps.logger.Printf("Failed to resolve credential: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	ps.logger.Printf("Uploading ACAP from %s to %s", payload.AcapURL, payload.URL)
 
-	// Download ACAP file from GitHub
-	acapResp, err := http.Get(payload.AcapURL)
+	// Download ACAP file from GitHub (abandoned if the browser goes away)
+	acapReq, err := http.NewRequestWithContext(r.Context(), "GET", payload.AcapURL, nil)
+	if err != nil {
+		ps.logger.Printf("Invalid ACAP URL: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid ACAP URL: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	acapResp, err := http.DefaultClient.Do(acapReq)
 	if err != nil {
 		ps.logger.Printf("Failed to download ACAP: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to download ACAP: %v", err), http.StatusInternalServerError)
@@ -369,25 +450,8 @@ func (ps *ProxyServer) handleUploadAcap(w http.ResponseWriter, r *http.Request)
 	buf.WriteString("--" + boundary + "--\r\n")
 
 	// Upload to camera with auth
-	httpReq, err := http.NewRequest("POST", payload.URL, &buf)
-	if err != nil {
-		ps.logger.Printf("Failed to create upload request: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to create request: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	httpReq.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
-
-	// Try authentication
-	proxyReq := &common.ProxyRequest{
-		URL:      payload.URL,
-		Method:   "POST",
-		Username: payload.Username,
-		Password: payload.Password,
-	}
-
 	// Make authenticated request
-	uploadResp, err := ps.makeAuthenticatedUpload(httpReq, proxyReq)
+	uploadResp, err := ps.makeAuthenticatedUpload(r.Context(), proxyReq, buf.Bytes(), "multipart/form-data; boundary="+boundary)
 	if err != nil {
 		ps.logger.Printf("Upload failed: %v", err)
 		http.Error(w, fmt.Sprintf("Upload failed: %v", err), http.StatusInternalServerError)
@@ -425,10 +489,11 @@ func (ps *ProxyServer) handleUploadLicense(w http.ResponseWriter, r *http.Reques
 	}
 
 	var payload struct {
-		URL        string `json:"url"`
-		Username   string `json:"username"`
-		Password   string `json:"password"`
-		LicenseXML string `json:"licenseXML"`
+		URL          string `json:"url"`
+		Username     string `json:"username"`
+		Password     string `json:"password"`
+		CredentialID string `json:"credentialId"`
+		LicenseXML   string `json:"licenseXML"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -437,6 +502,22 @@ func (ps *ProxyServer) handleUploadLicense(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	proxyReq := &common.ProxyRequest{
+		URL:          payload.URL,
+		Method:       "POST",
+		Username:     payload.Username,
+		Password:     payload.Password,
+		CredentialID: payload.CredentialID,
+	}
+	if proxyReq.CredentialID != "" && !ps.requireTrustedOrigin(w, r) {
+		return
+	}
+	if err := ps.vault.Resolve(proxyReq); err != nil {
+		ps.logger.Printf("Failed to resolve credential: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	ps.logger.Printf("Uploading license XML to %s (XML length: %d)", payload.URL, len(payload.LicenseXML))
 
 	// Create multipart form-data with license XML
@@ -452,24 +533,7 @@ func (ps *ProxyServer) handleUploadLicense(w http.ResponseWriter, r *http.Reques
 	buf.WriteString("--" + boundary + "--\r\n")
 
 	// Upload to camera with auth
-	httpReq, err := http.NewRequest("POST", payload.URL, &buf)
-	if err != nil {
-		ps.logger.Printf("Failed to create upload request: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to create request: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	httpReq.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
-
-	// Try authentication
-	proxyReq := &common.ProxyRequest{
-		URL:      payload.URL,
-		Method:   "POST",
-		Username: payload.Username,
-		Password: payload.Password,
-	}
-
-	uploadResp, err := ps.makeAuthenticatedUpload(httpReq, proxyReq)
+	uploadResp, err := ps.makeAuthenticatedUpload(r.Context(), proxyReq, buf.Bytes(), "multipart/form-data; boundary="+boundary)
 	if err != nil {
 		ps.logger.Printf("License upload failed: %v", err)
 		http.Error(w, fmt.Sprintf("Upload failed: %v", err), http.StatusInternalServerError)
@@ -493,47 +557,17 @@ func (ps *ProxyServer) handleUploadLicense(w http.ResponseWriter, r *http.Reques
 	})
 }
 
-func (ps *ProxyServer) makeAuthenticatedUpload(req *http.Request, proxyReq *common.ProxyRequest) (*http.Response, error) {
-	// Try Digest auth pattern
-	resp, err := ps.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != 401 {
-		return resp, nil // No auth needed or success
-	}
-	resp.Body.Close()
-
-	// Parse Digest challenge
-	authHeader := resp.Header.Get("WWW-Authenticate")
-	challenge, err := common.ParseDigestChallenge(authHeader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse auth challenge: %w", err)
-	}
-
-	// Read body for resend
-	var bodyBytes []byte
-	if req.Body != nil {
-		bodyBytes, _ = io.ReadAll(req.Body)
-	}
-
-	// Create new request with auth
-	req2, err := http.NewRequest(req.Method, req.URL.String(), bytes.NewReader(bodyBytes))
-	if err != nil {
-		return nil, err
-	}
-
-	// Copy headers
-	for k, v := range req.Header {
-		req2.Header[k] = v
-	}
-
-	// Calculate and add Digest auth
-	digestAuth := common.CalculateDigestAuth(proxyReq, challenge)
-	req2.Header.Set("Authorization", digestAuth)
-
-	return ps.client.Do(req2)
+// makeAuthenticatedUpload posts body to the camera with Digest auth
+// The body is rebuilt from bodyBytes for every attempt so it is never sent empty
+func (ps *ProxyServer) makeAuthenticatedUpload(ctx context.Context, proxyReq *common.ProxyRequest, bodyBytes []byte, contentType string) (*http.Response, error) {
+	return common.DoDigestRequest(ctx, ps.client, ps.digestCache, proxyReq, bodyBytes, func(ctx context.Context) (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, proxyReq.Method, proxyReq.URL, bytes.NewReader(bodyBytes))
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set("Content-Type", contentType)
+		return httpReq, nil
+	})
 }
 
 func (ps *ProxyServer) setCORSHeaders(w http.ResponseWriter, r *http.Request) {
@@ -545,7 +579,7 @@ func (ps *ProxyServer) setCORSHeaders(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 	}
 
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 }
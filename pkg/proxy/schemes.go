@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// authScheme identifies how a camera last accepted a request
+type authScheme string
+
+const (
+	authSchemeNone   authScheme = "none"
+	authSchemeBasic  authScheme = "basic"
+	authSchemeDigest authScheme = "digest"
+)
+
+// authSchemeCache remembers, per camera endpoint, which auth scheme last worked
+// so makeCameraRequest can skip the unauthenticated probe and scheme ordering
+// HTTP and HTTPS on the same host are separate entries: a camera may only accept
+// Basic over HTTPS, and vault credentials are never sent as Basic over HTTP
+type authSchemeCache struct {
+	mu      sync.RWMutex
+	schemes map[string]authScheme // scheme://host:port -> scheme
+}
+
+// newAuthSchemeCache creates an empty auth scheme cache
+func newAuthSchemeCache() *authSchemeCache {
+	return &authSchemeCache{
+		schemes: make(map[string]authScheme),
+	}
+}
+
+// Get returns the remembered scheme for the camera at rawURL
+func (ac *authSchemeCache) Get(rawURL string) (authScheme, bool) {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+	scheme, ok := ac.schemes[cameraEndpoint(rawURL)]
+	return scheme, ok
+}
+
+// Set remembers the scheme that worked for the camera at rawURL
+func (ac *authSchemeCache) Set(rawURL string, scheme authScheme) {
+	ac.mu.Lock()
+	ac.schemes[cameraEndpoint(rawURL)] = scheme
+	ac.mu.Unlock()
+}
+
+// Forget drops the remembered scheme for the camera at rawURL
+func (ac *authSchemeCache) Forget(rawURL string) {
+	ac.mu.Lock()
+	delete(ac.schemes, cameraEndpoint(rawURL))
+	ac.mu.Unlock()
+}
+
+// cameraEndpoint returns scheme://host:port for a camera URL, used as the cache key
+// The default port is filled in, so http://camera and http://camera:80 share an entry
+func cameraEndpoint(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return rawURL
+	}
+	scheme := strings.ToLower(parsed.Scheme)
+	port := parsed.Port()
+	if port == "" {
+		port = "80"
+		if scheme == "https" {
+			port = "443"
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(strings.ToLower(parsed.Hostname()), port)
+}
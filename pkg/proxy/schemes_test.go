@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"anava-camera-extension/pkg/common"
+)
+
+func TestCameraEndpoint(t *testing.T) {
+	tests := []struct {
+		rawURL string
+		want   string
+	}{
+		{"http://192.168.1.10/axis-cgi/basicdeviceid.cgi", "http://192.168.1.10:80"},
+		{"http://192.168.1.10:80/axis-cgi/param.cgi", "http://192.168.1.10:80"},
+		{"https://192.168.1.10/axis-cgi/basicdeviceid.cgi", "https://192.168.1.10:443"},
+		{"https://192.168.1.10:8443/", "https://192.168.1.10:8443"},
+		{"HTTP://Camera.Local/", "http://camera.local:80"},
+		{"http://[fe80::1]/", "http://[fe80::1]:80"},
+		{"not a url", "not a url"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rawURL, func(t *testing.T) {
+			if got := cameraEndpoint(tt.rawURL); got != tt.want {
+				t.Errorf("cameraEndpoint(%q) = %q, want %q", tt.rawURL, got, tt.want)
+			}
+		})
+	}
+}
+
+// schemeCamera only accepts one auth scheme and records the scheme of every request
+// Digest responses aren't verified; only which scheme the proxy picked matters here
+type schemeCamera struct {
+	mu     sync.Mutex
+	accept authScheme
+	seen   []authScheme
+}
+
+func (c *schemeCamera) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	got := authSchemeNone
+	switch header := r.Header.Get("Authorization"); {
+	case strings.HasPrefix(header, "Basic "):
+		got = authSchemeBasic
+	case strings.HasPrefix(header, "Digest "):
+		got = authSchemeDigest
+	}
+	c.seen = append(c.seen, got)
+
+	if got == c.accept {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"ok":true}`))
+		return
+	}
+	switch c.accept {
+	case authSchemeBasic:
+		w.Header().Set("WWW-Authenticate", `Basic realm="AXIS_ACCC8E000000"`)
+	case authSchemeDigest:
+		w.Header().Set("WWW-Authenticate", `Digest realm="AXIS_ACCC8E000000", nonce="abc123", qop="auth", algorithm=MD5`)
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+func TestMakeCameraRequestRememberedScheme(t *testing.T) {
+	tests := []struct {
+		name string
+		// remember is stored for the camera's host:port under rememberScheme before the request
+		rememberScheme string
+		remember       authScheme
+		accept         authScheme
+		wantSeen       []authScheme
+		wantRemembered authScheme
+	}{
+		{
+			name:   "nothing remembered runs the full sequence",
+			accept: authSchemeDigest,
+			// Probe, Digest challenge, Digest answer
+			wantSeen:       []authScheme{authSchemeNone, authSchemeNone, authSchemeDigest},
+			wantRemembered: authSchemeDigest,
+		},
+		{
+			name:           "remembered scheme skips the probe",
+			rememberScheme: "http",
+			remember:       authSchemeDigest,
+			accept:         authSchemeDigest,
+			wantSeen:       []authScheme{authSchemeNone, authSchemeDigest},
+			wantRemembered: authSchemeDigest,
+		},
+		{
+			name:           "failed remembered scheme falls back to the full sequence",
+			rememberScheme: "http",
+			remember:       authSchemeBasic,
+			accept:         authSchemeDigest,
+			wantSeen:       []authScheme{authSchemeBasic, authSchemeNone, authSchemeNone, authSchemeDigest},
+			wantRemembered: authSchemeDigest,
+		},
+		{
+			name:           "scheme remembered for HTTPS is not used for HTTP",
+			rememberScheme: "https",
+			remember:       authSchemeBasic,
+			accept:         authSchemeDigest,
+			wantSeen:       []authScheme{authSchemeNone, authSchemeNone, authSchemeDigest},
+			wantRemembered: authSchemeDigest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newTestProxyServer(t)
+			camera := &schemeCamera{accept: tt.accept}
+			server := httptest.NewServer(camera)
+			defer server.Close()
+
+			hostPort := strings.TrimPrefix(server.URL, "http://")
+			if tt.rememberScheme != "" {
+				ps.authSchemes.Set(tt.rememberScheme+"://"+hostPort+"/", tt.remember)
+			}
+
+			req := &common.ProxyRequest{URL: server.URL + "/axis-cgi/basicdeviceid.cgi", Method: "GET", Username: "root", Password: "pass"}
+			resp, err := ps.makeCameraRequest(context.Background(), req)
+			if err != nil {
+				t.Fatalf("makeCameraRequest: %v", err)
+			}
+			if resp.Status != http.StatusOK {
+				t.Errorf("status = %d, want 200", resp.Status)
+			}
+
+			camera.mu.Lock()
+			seen := camera.seen
+			camera.mu.Unlock()
+			if len(seen) != len(tt.wantSeen) {
+				t.Fatalf("schemes sent = %v, want %v", seen, tt.wantSeen)
+			}
+			for i := range seen {
+				if seen[i] != tt.wantSeen[i] {
+					t.Fatalf("schemes sent = %v, want %v", seen, tt.wantSeen)
+				}
+			}
+
+			if got, _ := ps.authSchemes.Get(req.URL); got != tt.wantRemembered {
+				t.Errorf("remembered scheme = %q, want %q", got, tt.wantRemembered)
+			}
+			if tt.rememberScheme == "https" {
+				// The HTTPS entry belongs to another endpoint and is left alone
+				if got, _ := ps.authSchemes.Get("https://" + hostPort + "/"); got != tt.remember {
+					t.Errorf("HTTPS remembered scheme = %q, want %q", got, tt.remember)
+				}
+			}
+		})
+	}
+}
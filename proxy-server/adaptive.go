@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Adaptive scan concurrency, ported from src/services/AdaptiveScanConfig.ts
+// Each batch of IPs runs fully in parallel; the next batch size is derived
+// from the previous batch's error/timeout rate and response times
+
+const (
+	// maxScanWorkers caps any preset or custom worker count
+	maxScanWorkers = 200
+	// slowResponseThreshold blocks growth while responders answer slower than this
+	slowResponseThreshold = time.Second
+)
+
+// Scan intensity presets
+const (
+	scanIntensityConservative = "conservative"
+	scanIntensityBalanced     = "balanced"
+	scanIntensityAggressive   = "aggressive"
+)
+
+// AdaptiveScanConfig controls how many IPs a scan checks concurrently
+type AdaptiveScanConfig struct {
+	InitialWorkers  int
+	MinWorkers      int
+	MaxWorkers      int
+	TargetErrorRate float64 // Below this (and with fast responses) the batch grows
+	MaxErrorRate    float64 // Above this (or on any timeout) the batch halves
+	InterBatchDelay time.Duration
+	IsLAN           bool // LAN scans grow faster
+}
+
+// scanOutcome classifies the result of checking one IP for the controller
+type scanOutcome int
+
+const (
+	scanOutcomeSkipped    scanOutcome = iota // Not checked (scan paused then cancelled)
+	scanOutcomeResponded                     // Device answered over HTTP(S)
+	scanOutcomeNoResponse                    // Nothing listening - normal in a sweep, not an error
+	scanOutcomeTimeout                       // Connected but the request timed out
+	scanOutcomeError                         // TLS failures, resets and other errors
+)
+
+// scanResult is one IP's outcome and how long it took
+type scanResult struct {
+	outcome  scanOutcome
+	duration time.Duration
+}
+
+// batchMetrics summarizes one batch (ScanMetrics in the TypeScript version)
+type batchMetrics struct {
+	successCount    int
+	timeoutCount    int
+	errorCount      int
+	totalAttempts   int
+	avgResponseTime time.Duration // Over responding devices only
+}
+
+// adaptiveController adjusts the batch size between batches
+type adaptiveController struct {
+	config      AdaptiveScanConfig
+	currentSize int
+	history     []batchMetrics
+}
+
+// presetAdaptiveScanConfig returns the settings for an intensity preset
+// An empty intensity selects balanced
+func presetAdaptiveScanConfig(intensity string) (AdaptiveScanConfig, error) {
+	config := AdaptiveScanConfig{
+		MinWorkers:      5,
+		TargetErrorRate: 0.02,
+		IsLAN:           true,
+	}
+
+	switch intensity {
+	case scanIntensityConservative:
+		config.InitialWorkers = 15
+		config.MaxWorkers = 30
+		config.MaxErrorRate = 0.02
+		config.InterBatchDelay = 100 * time.Millisecond
+	case scanIntensityBalanced, "":
+		config.InitialWorkers = 30
+		config.MaxWorkers = 80
+		config.MaxErrorRate = 0.05
+		config.InterBatchDelay = 50 * time.Millisecond
+	case scanIntensityAggressive:
+		config.InitialWorkers = 50
+		config.MaxWorkers = 150
+		config.MaxErrorRate = 0.10
+		config.InterBatchDelay = 20 * time.Millisecond
+	default:
+		return AdaptiveScanConfig{}, fmt.Errorf("unknown scan intensity: %s", intensity)
+	}
+
+	return config, nil
+}
+
+// newAdaptiveScanConfig applies the ScanRequest overrides on top of its intensity preset
+func newAdaptiveScanConfig(req *ScanRequest, ips []string) (AdaptiveScanConfig, error) {
+	config, err := presetAdaptiveScanConfig(req.Intensity)
+	if err != nil {
+		return AdaptiveScanConfig{}, err
+	}
+
+	if req.MinWorkers != 0 {
+		config.MinWorkers = req.MinWorkers
+	}
+	if req.MaxWorkers != 0 {
+		config.MaxWorkers = req.MaxWorkers
+	}
+	if req.TargetErrorRate != 0 {
+		config.TargetErrorRate = req.TargetErrorRate
+	}
+	if req.MaxErrorRate != 0 {
+		config.MaxErrorRate = req.MaxErrorRate
+	}
+	if req.InterBatchDelayMs != 0 {
+		config.InterBatchDelay = time.Duration(req.InterBatchDelayMs) * time.Millisecond
+	}
+
+	switch {
+	case config.MinWorkers < 1 || config.MaxWorkers > maxScanWorkers:
+		return AdaptiveScanConfig{}, fmt.Errorf("workers must be between 1 and %d", maxScanWorkers)
+	case config.MinWorkers > config.MaxWorkers:
+		return AdaptiveScanConfig{}, fmt.Errorf("min_workers (%d) exceeds max_workers (%d)", config.MinWorkers, config.MaxWorkers)
+	case config.TargetErrorRate <= 0 || config.TargetErrorRate >= 1:
+		return AdaptiveScanConfig{}, fmt.Errorf("target_error_rate must be between 0 and 1")
+	case config.MaxErrorRate <= 0 || config.MaxErrorRate >= 1:
+		return AdaptiveScanConfig{}, fmt.Errorf("max_error_rate must be between 0 and 1")
+	case config.InterBatchDelay < 0:
+		return AdaptiveScanConfig{}, fmt.Errorf("inter_batch_delay_ms must not be negative")
+	}
+
+	// Keep the preset's starting point inside the requested bounds
+	config.InitialWorkers = max(config.MinWorkers, min(config.InitialWorkers, config.MaxWorkers))
+
+	if len(ips) > 0 {
+		config.IsLAN = isPrivateIPv4(net.ParseIP(ips[0]))
+	}
+	if !config.IsLAN {
+		config.InitialWorkers = max(config.MinWorkers, min(20, config.InitialWorkers))
+	}
+
+	return config, nil
+}
+
+// newAdaptiveController creates a controller starting at config.InitialWorkers
+func newAdaptiveController(config AdaptiveScanConfig) *adaptiveController {
+	return &adaptiveController{
+		config:      config,
+		currentSize: config.InitialWorkers,
+	}
+}
+
+// BatchSize returns the number of IPs to check concurrently in the next batch
+func (c *adaptiveController) BatchSize() int {
+	return c.currentSize
+}
+
+// Adjust records a batch's metrics and returns the next batch size
+func (c *adaptiveController) Adjust(scanID string, metrics batchMetrics) int {
+	if metrics.totalAttempts == 0 {
+		return c.currentSize
+	}
+	c.history = append(c.history, metrics)
+
+	errorRate := metrics.errorRate()
+	previous := c.currentSize
+
+	if metrics.timeoutCount > 0 || errorRate > c.config.MaxErrorRate {
+		// Network is struggling - reduce batch size
+		c.currentSize = max(c.config.MinWorkers, c.currentSize/2)
+		if c.currentSize < previous {
+			logger.Printf("[Scan %s] ⚠️ High error rate (%.1f%%, %d timeouts). Reducing workers from %d to %d",
+				scanID, errorRate*100, metrics.timeoutCount, previous, c.currentSize)
+		}
+	} else if errorRate < c.config.TargetErrorRate && metrics.avgResponseTime < slowResponseThreshold {
+		// Network is handling it well - increase batch size
+		increase := 5
+		if c.config.IsLAN {
+			increase = 10
+		}
+		c.currentSize = min(c.config.MaxWorkers, c.currentSize+increase)
+		if c.currentSize > previous {
+			logger.Printf("[Scan %s] ✅ Good performance (%.1f%% errors, %v avg). Increasing workers from %d to %d",
+				scanID, errorRate*100, metrics.avgResponseTime.Round(time.Millisecond), previous, c.currentSize)
+		}
+	}
+
+	return c.currentSize
+}
+
+// InterBatchDelay returns the pause before the next batch, tripled while
+// the last three batches averaged above the maximum error rate
+func (c *adaptiveController) InterBatchDelay() time.Duration {
+	recent := c.history[max(0, len(c.history)-3):]
+	if len(recent) > 0 {
+		var sum float64
+		for _, m := range recent {
+			sum += m.errorRate()
+		}
+		if sum/float64(len(recent)) > c.config.MaxErrorRate {
+			return c.config.InterBatchDelay * 3
+		}
+	}
+	return c.config.InterBatchDelay
+}
+
+// newBatchMetrics summarizes a batch's results, ignoring skipped IPs
+func newBatchMetrics(results []scanResult) batchMetrics {
+	var metrics batchMetrics
+	var responseTime time.Duration
+	for _, result := range results {
+		switch result.outcome {
+		case scanOutcomeSkipped:
+			continue
+		case scanOutcomeResponded:
+			metrics.successCount++
+			responseTime += result.duration
+		case scanOutcomeTimeout:
+			metrics.timeoutCount++
+		case scanOutcomeError:
+			metrics.errorCount++
+		}
+		metrics.totalAttempts++
+	}
+	if metrics.successCount > 0 {
+		metrics.avgResponseTime = responseTime / time.Duration(metrics.successCount)
+	}
+	return metrics
+}
+
+// errorRate returns the share of attempts that failed or timed out
+func (m batchMetrics) errorRate() float64 {
+	if m.totalAttempts == 0 {
+		return 0
+	}
+	return float64(m.errorCount+m.timeoutCount) / float64(m.totalAttempts)
+}
+
+// classifyScanError maps a camera request error to a controller outcome
+// Failing to connect at all means nothing is there, which says nothing about network load;
+// a device that rejected the credentials answered normally
+func classifyScanError(err error) scanOutcome {
+	if isAuthChallengeError(err) {
+		return scanOutcomeResponded
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return scanOutcomeNoResponse
+	}
+	if isConnectionRefusedError(err) {
+		return scanOutcomeNoResponse
+	}
+	if isTimeoutError(err) {
+		return scanOutcomeTimeout
+	}
+	return scanOutcomeError
+}
+
+// isPrivateIPv4 reports whether ip is in an RFC 1918 range
+func isPrivateIPv4(ip net.IP) bool {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return false
+	}
+	return ip4[0] == 10 ||
+		(ip4[0] == 172 && ip4[1] >= 16 && ip4[1] <= 31) ||
+		(ip4[0] == 192 && ip4[1] == 168)
+}
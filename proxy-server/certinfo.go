@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/x509"
+	"time"
+)
+
+// certExpiryWarning is how far ahead expiring camera certificates are reported
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// Expiry states reported for camera certificates
+const (
+	certExpiryOK       = "ok"
+	certExpiryExpiring = "expiring"
+	certExpiryExpired  = "expired"
+)
+
+// CertificateSummary describes the TLS certificate a camera presented
+type CertificateSummary struct {
+	Fingerprint  string    `json:"fingerprint"`
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SANs         []string  `json:"sans,omitempty"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	ExpiryStatus string    `json:"expiryStatus"` // ok, expiring or expired
+}
+
+// newCertificateSummary describes cert and its expiry state at now
+func newCertificateSummary(cert *x509.Certificate, now time.Time) *CertificateSummary {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+
+	status := certExpiryOK
+	switch {
+	case now.After(cert.NotAfter):
+		status = certExpiryExpired
+	case cert.NotAfter.Sub(now) < certExpiryWarning:
+		status = certExpiryExpiring
+	}
+
+	return &CertificateSummary{
+		Fingerprint:  calculateCertFingerprint(cert),
+		Subject:      cert.Subject.String(),
+		Issuer:       cert.Issuer.String(),
+		SANs:         sans,
+		NotBefore:    cert.NotBefore.UTC(),
+		NotAfter:     cert.NotAfter.UTC(),
+		ExpiryStatus: status,
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Certificate store shared with the local connector (pkg/proxy/certstore.go)
+// Both read and write certificate-fingerprints.json, so the format must stay in sync
+
+const (
+	certStoreVersion = 3
+	// lastSeenSaveInterval limits disk writes when only LastSeen changes
+	lastSeenSaveInterval = time.Hour
+	// maxCertHistory is the number of replaced certificates kept per address
+	maxCertHistory = 10
+)
+
+// CertificateInfo is the metadata recorded for a camera certificate
+// Pins imported or migrated from older stores only have a fingerprint until the camera is seen again
+type CertificateInfo struct {
+	Fingerprint string    `json:"fingerprint"`
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	SANs        []string  `json:"sans,omitempty"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+}
+
+// certEntry is the pin for one camera address
+type certEntry struct {
+	CertificateInfo
+	History []CertificateInfo `json:"history,omitempty"` // Previously pinned certificates, newest first
+	// ChainValidated entries come from cameras validated by certificate chain: their
+	// metadata is kept for expiry reporting, but the fingerprint is not a pin
+	ChainValidated bool `json:"chainValidated,omitempty"`
+}
+
+// certStoreFile is the on-disk format of certificate-fingerprints.json
+// Version 1 files were a bare host -> fingerprint map keyed by TLS ServerName;
+// version 2 keyed fingerprint strings by ip:port
+type certStoreFile struct {
+	Version int                   `json:"version"`
+	Pins    map[string]*certEntry `json:"pins"`              // ip:port -> pinned certificate
+	Aliases map[string]string     `json:"aliases,omitempty"` // hostname:port -> ip:port
+}
+
+// certStoreFileV2 is the version 2 on-disk format
+type certStoreFileV2 struct {
+	Pins    map[string]string `json:"pins"`
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+// CertificateStore manages certificate fingerprints for known cameras
+// Pins are keyed by the dialed ip:port; hostnames used to reach a camera are
+// recorded as aliases of that address
+type CertificateStore struct {
+	mu       sync.RWMutex
+	saveMu   sync.Mutex            // Serializes save
+	entries  map[string]*certEntry // ip:port -> pinned certificate
+	aliases  map[string]string     // hostname:port -> ip:port
+	filePath string
+}
+
+// NewCertificateStore creates a new certificate store
+func NewCertificateStore(filePath string) *CertificateStore {
+	store := &CertificateStore{
+		entries:  make(map[string]*certEntry),
+		aliases:  make(map[string]string),
+		filePath: filePath,
+	}
+	store.load()
+	return store
+}
+
+// load reads saved fingerprints from disk, migrating older store versions
+func (cs *CertificateStore) load() {
+	data, err := os.ReadFile(cs.filePath)
+	if err != nil {
+		// File doesn't exist yet - that's okay
+		return
+	}
+
+	var probe struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		logger.Printf("Warning: Failed to load certificate store: %v", err)
+		return
+	}
+
+	var file certStoreFile
+	switch probe.Version {
+	case 0:
+		var legacy map[string]string
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			logger.Printf("Warning: Failed to load certificate store: %v", err)
+			return
+		}
+		file = cs.migrateLegacy(legacy)
+
+	case 2:
+		var v2 certStoreFileV2
+		if err := json.Unmarshal(data, &v2); err != nil {
+			logger.Printf("Warning: Failed to load certificate store: %v", err)
+			return
+		}
+		file = certStoreFile{
+			Version: certStoreVersion,
+			Pins:    entriesFromFingerprints(v2.Pins),
+			Aliases: v2.Aliases,
+		}
+
+	case certStoreVersion:
+		if err := json.Unmarshal(data, &file); err != nil {
+			logger.Printf("Warning: Failed to load certificate store: %v", err)
+			return
+		}
+
+	default:
+		logger.Printf("Warning: Unsupported certificate store version: %d", probe.Version)
+		return
+	}
+
+	cs.mu.Lock()
+	for key, entry := range file.Pins {
+		if entry != nil {
+			cs.entries[key] = entry
+		}
+	}
+	for alias, key := range file.Aliases {
+		cs.aliases[alias] = key
+	}
+	cs.mu.Unlock()
+
+	logger.Printf("Loaded %d certificate fingerprints", len(file.Pins))
+
+	if probe.Version != certStoreVersion {
+		logger.Printf("Migrated certificate store from version %d to %d", probe.Version, certStoreVersion)
+		cs.save()
+	}
+}
+
+// migrateLegacy converts a version 1 (ServerName-keyed) store
+// IP pins move to ip:443; hostname pins are kept under hostname:443 and are
+// re-keyed to the dialed address on the next successful connection
+func (cs *CertificateStore) migrateLegacy(legacy map[string]string) certStoreFile {
+	fingerprints := make(map[string]string, len(legacy))
+	for host, fp := range legacy {
+		if host == "" {
+			// Every camera reached by IP shared this slot - it can't be attributed to one
+			logger.Printf("Warning: Dropping certificate pin with empty host during migration")
+			continue
+		}
+		fingerprints[pinKey(host)] = fp
+	}
+
+	return certStoreFile{
+		Version: certStoreVersion,
+		Pins:    entriesFromFingerprints(fingerprints),
+	}
+}
+
+// entriesFromFingerprints builds metadata-less entries from key -> fingerprint pins
+func entriesFromFingerprints(fingerprints map[string]string) map[string]*certEntry {
+	entries := make(map[string]*certEntry, len(fingerprints))
+	for key, fp := range fingerprints {
+		entries[key] = &certEntry{CertificateInfo: CertificateInfo{Fingerprint: fp}}
+	}
+	return entries
+}
+
+// save writes fingerprints to disk
+func (cs *CertificateStore) save() {
+	// Held across marshal and write so the newest snapshot is always written last
+	cs.saveMu.Lock()
+	defer cs.saveMu.Unlock()
+
+	cs.mu.RLock()
+	data, err := json.MarshalIndent(certStoreFile{
+		Version: certStoreVersion,
+		Pins:    cs.entries,
+		Aliases: cs.aliases,
+	}, "", "  ")
+	cs.mu.RUnlock()
+
+	if err != nil {
+		logger.Printf("Error marshaling certificate store: %v", err)
+		return
+	}
+
+	// Write to a temp file first so a crash never leaves a truncated store
+	tmpPath := cs.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		logger.Printf("Error saving certificate store: %v", err)
+		return
+	}
+	if err := os.Rename(tmpPath, cs.filePath); err != nil {
+		logger.Printf("Error saving certificate store: %v", err)
+	}
+}
+
+// newCertificateInfo records the metadata of cert, first and last seen at now
+func newCertificateInfo(cert *x509.Certificate, now time.Time) CertificateInfo {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+
+	return CertificateInfo{
+		Fingerprint: calculateCertFingerprint(cert),
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		SANs:        sans,
+		NotBefore:   cert.NotBefore.UTC(),
+		NotAfter:    cert.NotAfter.UTC(),
+		FirstSeen:   now,
+		LastSeen:    now,
+	}
+}
+
+// replace pins info for the entry, moving the current certificate into its history
+func (entry *certEntry) replace(info CertificateInfo) {
+	if entry.Fingerprint != "" {
+		entry.History = append([]CertificateInfo{entry.CertificateInfo}, entry.History...)
+		if len(entry.History) > maxCertHistory {
+			entry.History = entry.History[:maxCertHistory]
+		}
+	}
+	entry.CertificateInfo = info
+}
+
+// pinKey normalizes a host or host:port to the lower-case host:port form used as a
+// store key; the port defaults to 443
+func pinKey(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		return net.JoinHostPort(h, port)
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), "443")
+}
+
+// Lookup returns the pinned fingerprint for a connection to addr (the dialed ip:port)
+// requested as host (host:port from the URL). Falls back to the address host was last
+// seen at, then to a legacy pin keyed by host
+func (cs *CertificateStore) Lookup(addr, host string) (string, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	if entry, ok := cs.entries[pinKey(addr)]; ok && !entry.ChainValidated {
+		return entry.Fingerprint, true
+	}
+	hostKey := pinKey(host)
+	if target, ok := cs.aliases[hostKey]; ok {
+		if entry, ok := cs.entries[target]; ok && !entry.ChainValidated {
+			return entry.Fingerprint, true
+		}
+	}
+	if entry, ok := cs.entries[hostKey]; ok && !entry.ChainValidated {
+		return entry.Fingerprint, true
+	}
+	return "", false
+}
+
+// Pin records cert for addr (new pin or matching certificate) and records host as an
+// alias when it differs. Fills in metadata missing from migrated pins and updates last seen
+// Only writes to disk when something changed
+func (cs *CertificateStore) Pin(addr, host string, cert *x509.Certificate) {
+	cs.record(addr, host, cert, true)
+}
+
+// Observe records the metadata of a chain-validated certificate for addr without pinning it,
+// so expiry reporting covers cameras in chain mode. An existing pin for addr is left in place
+func (cs *CertificateStore) Observe(addr, host string, cert *x509.Certificate) {
+	cs.record(addr, host, cert, false)
+}
+
+// record implements Pin (pin) and Observe (!pin)
+func (cs *CertificateStore) record(addr, host string, cert *x509.Certificate, pin bool) {
+	addrKey, hostKey := pinKey(addr), pinKey(host)
+	now := time.Now().UTC()
+	info := newCertificateInfo(cert, now)
+
+	cs.mu.Lock()
+	changed := false
+
+	entry, ok := cs.entries[addrKey]
+	if !ok && hostKey != addrKey {
+		if legacy, isLegacy := cs.entries[hostKey]; isLegacy {
+			// Migrated hostname pin - now keyed by address
+			entry, ok = legacy, true
+			delete(cs.entries, hostKey)
+			cs.entries[addrKey] = entry
+			changed = true
+		}
+	}
+
+	switch {
+	case !ok:
+		entry = &certEntry{CertificateInfo: info, ChainValidated: !pin}
+		cs.entries[addrKey] = entry
+		changed = true
+
+	case entry.Fingerprint != info.Fingerprint:
+		if !pin && !entry.ChainValidated {
+			// Keep the pin in case the camera goes back to pinning mode
+			break
+		}
+		entry.replace(info)
+		changed = true
+
+	default:
+		if entry.NotAfter.IsZero() {
+			// Imported or migrated pin seen for the first time
+			firstSeen := entry.FirstSeen
+			entry.CertificateInfo = info
+			if !firstSeen.IsZero() {
+				entry.FirstSeen = firstSeen
+			}
+			changed = true
+		}
+		if now.Sub(entry.LastSeen) > lastSeenSaveInterval {
+			changed = true
+		}
+		entry.LastSeen = now
+	}
+
+	if pin && entry.ChainValidated {
+		entry.ChainValidated = false
+		changed = true
+	}
+
+	if hostKey != addrKey && cs.aliases[hostKey] != addrKey {
+		cs.aliases[hostKey] = addrKey
+		changed = true
+	}
+	cs.mu.Unlock()
+
+	if changed {
+		cs.save()
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// credentialPayload is the body accepted by the credential endpoints
+type credentialPayload struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// AllowedHosts are the camera IPs, CIDR subnets and hostnames the credential may be used for
+	AllowedHosts []string `json:"allowedHosts"`
+}
+
+// handleCredentials lists (GET), adds (POST) and deletes (DELETE ?id=) vault credentials
+// Passwords are never returned; usernames are sanitized
+func handleCredentials(w http.ResponseWriter, r *http.Request) {
+	if !requireTrustedOrigin(w, r) || !setCORSHeaders(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "OPTIONS":
+		w.WriteHeader(http.StatusOK)
+
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"credentials": credentialVault.List(),
+		})
+
+	case "POST":
+		var payload credentialPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			logger.Printf("Failed to decode credentials request: %v", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		summary, err := credentialVault.Add(payload.Name, payload.Username, payload.Password, payload.AllowedHosts)
+		if err != nil {
+			logger.Printf("Failed to add credential: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		logger.Printf("Added credential %s (user: %s)", summary.ID, summary.Username)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(summary)
+
+	case "DELETE":
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "id required", http.StatusBadRequest)
+			return
+		}
+
+		if err := credentialVault.Delete(id); err != nil {
+			logger.Printf("Failed to delete credential %s: %v", id, err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		logger.Printf("Deleted credential %s", id)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRotateCredential replaces the password (and optionally username) of a vault credential
+func handleRotateCredential(w http.ResponseWriter, r *http.Request) {
+	if !requireTrustedOrigin(w, r) || !setCORSHeaders(w, r) {
+		return
+	}
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload credentialPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		logger.Printf("Failed to decode credential rotate request: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	summary, err := credentialVault.Rotate(payload.ID, payload.Username, payload.Password, payload.AllowedHosts)
+	if err != nil {
+		logger.Printf("Failed to rotate credential %s: %v", payload.ID, err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	logger.Printf("Rotated credential %s", summary.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
@@ -2,4 +2,10 @@ module anava-proxy
 
 go 1.23.5
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require (
+	anava-camera-extension v0.0.0
+	github.com/gorilla/websocket v1.5.3
+)
+
+// The credential vault is shared with the local connector (pkg/common)
+replace anava-camera-extension => ../
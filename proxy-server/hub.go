@@ -0,0 +1,171 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+)
+
+// scanClientBuffer is how many events a subscriber may fall behind before it is dropped
+const scanClientBuffer = 256
+
+// scanClient is one WebSocket subscriber of a scan
+type scanClient struct {
+	conn *websocket.Conn
+	send chan ScanProgress // Closed when the client is dropped or the scan finishes
+}
+
+// All counters, found devices and subscribers of an ActiveScan are owned by
+// the methods below and only touched under scan.mu, so every event carries
+// counts consistent with the events before it
+
+// record counts ip as scanned with its result status, adds the device found
+// there (if any) and broadcasts the resulting progress
+func (scan *ActiveScan) record(ip, status string, device, nonCamera map[string]interface{}) {
+	scan.mu.Lock()
+	defer scan.mu.Unlock()
+
+	scan.scannedCount++
+	switch status {
+	case scanStatusAuthFailed:
+		scan.authFailed++
+	case scanStatusExcluded:
+		scan.excluded++
+	}
+	if device != nil {
+		scan.devicesFound++
+		if device["deviceType"] == deviceTypeCamera {
+			scan.camerasFound++
+		}
+		scan.devices = append(scan.devices, device)
+	}
+	if nonCamera != nil {
+		scan.aliveHosts++
+		scan.nonCameras = append(scan.nonCameras, nonCamera)
+	}
+
+	progress := scan.progressLocked("")
+	progress.IP = ip
+	progress.Status = status
+	progress.Camera = device
+	progress.NonCamera = nonCamera
+	scan.broadcastLocked(progress)
+}
+
+// announce broadcasts a state change with the current counts
+func (scan *ActiveScan) announce(state string) ScanProgress {
+	scan.mu.Lock()
+	defer scan.mu.Unlock()
+
+	progress := scan.progressLocked(state)
+	scan.broadcastLocked(progress)
+	return progress
+}
+
+// finish sends the final message to every client and closes their channels
+// Later subscribers receive the final snapshot only
+func (scan *ActiveScan) finish(state string) ScanProgress {
+	scan.mu.Lock()
+	defer scan.mu.Unlock()
+
+	final := scan.progressLocked(state)
+	final.IsComplete = true
+	scan.finished = true
+	scan.final = final
+
+	for client := range scan.clients {
+		select {
+		case client.send <- final:
+		default:
+			logger.Printf("[Scan %s] Client too slow for completion message", scan.ID)
+		}
+		delete(scan.clients, client)
+		close(client.send)
+	}
+	return final
+}
+
+// Snapshot returns the current progress with every device found so far
+func (scan *ActiveScan) Snapshot() ScanProgress {
+	scan.mu.Lock()
+	defer scan.mu.Unlock()
+	return scan.snapshotLocked()
+}
+
+// subscribe registers a client and returns it with a snapshot of the scan so far
+// Registration and snapshot happen under one lock so no event is missed or sent twice
+// A finished scan returns a nil client and its final snapshot
+func (scan *ActiveScan) subscribe(conn *websocket.Conn) (*scanClient, ScanProgress) {
+	scan.mu.Lock()
+	defer scan.mu.Unlock()
+
+	snapshot := scan.snapshotLocked()
+	if scan.finished {
+		return nil, snapshot
+	}
+
+	client := &scanClient{
+		conn: conn,
+		send: make(chan ScanProgress, scanClientBuffer),
+	}
+	scan.clients[client] = true
+	return client, snapshot
+}
+
+// unsubscribe removes a client and closes its send channel
+func (scan *ActiveScan) unsubscribe(client *scanClient) {
+	scan.mu.Lock()
+	defer scan.mu.Unlock()
+
+	if scan.clients[client] {
+		delete(scan.clients, client)
+		close(client.send)
+	}
+}
+
+// broadcastLocked sends progress to every client
+// A client whose buffer is full is dropped; it can reconnect and resync from the snapshot
+// Caller must hold scan.mu
+func (scan *ActiveScan) broadcastLocked(progress ScanProgress) {
+	if scan.finished {
+		return
+	}
+
+	for client := range scan.clients {
+		select {
+		case client.send <- progress:
+		default:
+			logger.Printf("[Scan %s] Client too slow, disconnecting", scan.ID)
+			delete(scan.clients, client)
+			close(client.send)
+		}
+	}
+}
+
+// progressLocked returns a progress message with the scan's current counts
+// Caller must hold scan.mu
+func (scan *ActiveScan) progressLocked(state string) ScanProgress {
+	return ScanProgress{
+		ScanID:       scan.ID,
+		ScannedCount: scan.scannedCount,
+		TotalIPs:     scan.TotalIPs,
+		CamerasFound: scan.camerasFound,
+		DevicesFound: scan.devicesFound,
+		AuthFailed:   scan.authFailed,
+		AliveHosts:   scan.aliveHosts,
+		Excluded:     scan.excluded,
+		PercentDone:  float64(scan.scannedCount) / float64(scan.TotalIPs) * 100.0,
+		State:        state,
+	}
+}
+
+// snapshotLocked returns the current progress with every device found so far
+// Caller must hold scan.mu
+func (scan *ActiveScan) snapshotLocked() ScanProgress {
+	snapshot := scan.final
+	if !scan.finished {
+		snapshot = scan.progressLocked(scan.currentState())
+	}
+	snapshot.Snapshot = true
+	snapshot.Cameras = append([]map[string]interface{}(nil), scan.devices...)
+	snapshot.NonCameras = append([]map[string]interface{}(nil), scan.nonCameras...)
+	return snapshot
+}
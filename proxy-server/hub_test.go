@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func newTestScan(totalIPs int) *ActiveScan {
+	return &ActiveScan{
+		ID:       "test",
+		TotalIPs: totalIPs,
+		clients:  make(map[*scanClient]bool),
+		state:    scanStateScanning,
+	}
+}
+
+// recordTestIP records the i-th IP: every 10th is a camera, every 7th an alive non-camera
+func recordTestIP(scan *ActiveScan, i int) {
+	ip := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+	var device, nonCamera map[string]interface{}
+	switch {
+	case i%10 == 0:
+		device = map[string]interface{}{"ip": ip, "deviceType": deviceTypeCamera}
+	case i%7 == 0:
+		nonCamera = map[string]interface{}{"ip": ip, "status": scanStatusNoResponse}
+	}
+	scan.record(ip, scanStatusNoResponse, device, nonCamera)
+}
+
+// checkEvents reads a client's events until its channel closes, stop events were read or done is closed
+// Every event must follow the previous one (or the snapshot) by exactly one recorded IP
+func checkEvents(t *testing.T, client *scanClient, snapshot ScanProgress, stop int, done <-chan struct{}) {
+	t.Helper()
+	last := snapshot.ScannedCount
+	for received := 0; received < stop; received++ {
+		var progress ScanProgress
+		var ok bool
+		select {
+		case progress, ok = <-client.send:
+		case <-done:
+			return
+		}
+		if !ok {
+			return
+		}
+		if progress.IsComplete {
+			if progress.ScannedCount != last {
+				t.Errorf("final count %d after event %d", progress.ScannedCount, last)
+			}
+			return
+		}
+		if progress.ScannedCount != last+1 {
+			t.Errorf("event count %d after %d: missed or duplicated event", progress.ScannedCount, last)
+			return
+		}
+		last = progress.ScannedCount
+	}
+}
+
+func TestScanHubConcurrentRecord(t *testing.T) {
+	const (
+		totalIPs    = 5000
+		recorders   = 16
+		subscribers = 8
+	)
+	scan := newTestScan(totalIPs)
+
+	// Clients keep subscribing, reading a few events and unsubscribing while IPs are recorded
+	done := make(chan struct{})
+	var subscribeWG sync.WaitGroup
+	for s := 0; s < subscribers; s++ {
+		subscribeWG.Add(1)
+		go func() {
+			defer subscribeWG.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				client, snapshot := scan.subscribe(nil)
+				if len(snapshot.Cameras) != snapshot.CamerasFound {
+					t.Errorf("snapshot lists %d cameras, counts %d", len(snapshot.Cameras), snapshot.CamerasFound)
+				}
+				if len(snapshot.NonCameras) != snapshot.AliveHosts {
+					t.Errorf("snapshot lists %d non-cameras, counts %d", len(snapshot.NonCameras), snapshot.AliveHosts)
+				}
+				checkEvents(t, client, snapshot, 20, done)
+				scan.unsubscribe(client)
+				_ = scan.Snapshot()
+			}
+		}()
+	}
+
+	var recordWG sync.WaitGroup
+	next := make(chan int, totalIPs)
+	for i := 0; i < totalIPs; i++ {
+		next <- i
+	}
+	close(next)
+	for r := 0; r < recorders; r++ {
+		recordWG.Add(1)
+		go func() {
+			defer recordWG.Done()
+			for i := range next {
+				recordTestIP(scan, i)
+			}
+		}()
+	}
+
+	recordWG.Wait()
+
+	// One client stays subscribed through finish
+	client, snapshot := scan.subscribe(nil)
+	close(done)
+	subscribeWG.Wait()
+
+	final := scan.finish(scanStateComplete)
+	checkEvents(t, client, snapshot, totalIPs, nil)
+	if _, ok := <-client.send; ok {
+		t.Error("client channel still open after finish")
+	}
+
+	wantCameras, wantAlive := 0, 0
+	for i := 0; i < totalIPs; i++ {
+		switch {
+		case i%10 == 0:
+			wantCameras++
+		case i%7 == 0:
+			wantAlive++
+		}
+	}
+
+	if final.ScannedCount != final.TotalIPs || final.TotalIPs != totalIPs {
+		t.Errorf("final scanned %d of %d, want %d", final.ScannedCount, final.TotalIPs, totalIPs)
+	}
+	if final.CamerasFound != wantCameras || final.DevicesFound != wantCameras {
+		t.Errorf("final cameras %d, devices %d, want %d", final.CamerasFound, final.DevicesFound, wantCameras)
+	}
+	if final.AliveHosts != wantAlive {
+		t.Errorf("final alive hosts %d, want %d", final.AliveHosts, wantAlive)
+	}
+	if !final.IsComplete || final.PercentDone != 100 {
+		t.Errorf("final complete %v at %.1f%%", final.IsComplete, final.PercentDone)
+	}
+
+	snapshot = scan.Snapshot()
+	if snapshot.ScannedCount != totalIPs || len(snapshot.Cameras) != wantCameras || len(snapshot.NonCameras) != wantAlive {
+		t.Errorf("snapshot scanned %d with %d cameras and %d non-cameras", snapshot.ScannedCount, len(snapshot.Cameras), len(snapshot.NonCameras))
+	}
+}
+
+func TestScanHubSlowClientDropped(t *testing.T) {
+	scan := newTestScan(scanClientBuffer + 10)
+	client, _ := scan.subscribe(nil)
+
+	for i := 0; i < scanClientBuffer+10; i++ {
+		recordTestIP(scan, i+1)
+	}
+
+	received := 0
+	for range client.send {
+		received++
+	}
+	if received != scanClientBuffer {
+		t.Errorf("received %d events before the drop, want %d", received, scanClientBuffer)
+	}
+
+	// Unsubscribing a dropped client must not close its channel again
+	scan.unsubscribe(client)
+	if scan.Snapshot().ScannedCount != scanClientBuffer+10 {
+		t.Errorf("dropping a client lost records")
+	}
+}
+
+func TestScanHubSubscribeAfterFinish(t *testing.T) {
+	scan := newTestScan(3)
+	for i := 1; i <= 3; i++ {
+		recordTestIP(scan, i)
+	}
+	scan.announce(scanStatePaused)
+	final := scan.finish(scanStateComplete)
+
+	client, snapshot := scan.subscribe(nil)
+	if client != nil {
+		t.Fatal("subscribe after finish returned a client")
+	}
+	if !snapshot.Snapshot || !snapshot.IsComplete || snapshot.State != scanStateComplete {
+		t.Errorf("snapshot %+v is not the final snapshot", snapshot)
+	}
+	if snapshot.ScannedCount != final.ScannedCount || snapshot.ScannedCount != 3 {
+		t.Errorf("snapshot scanned %d, final %d, want 3", snapshot.ScannedCount, final.ScannedCount)
+	}
+
+	// Late results don't reach anyone and don't change the final snapshot
+	scan.record("10.0.0.9", scanStatusNoResponse, nil, nil)
+	if got := scan.Snapshot().ScannedCount; got != 3 {
+		t.Errorf("snapshot after finish scanned %d, want 3", got)
+	}
+}
+
+func TestScanHubExcludedDevices(t *testing.T) {
+	scan := newTestScan(3)
+	scan.record("10.0.0.1", scanStatusCameraFound, map[string]interface{}{"ip": "10.0.0.1", "deviceType": deviceTypeCamera}, nil)
+	scan.record("10.0.0.2", scanStatusExcluded, nil, nil)
+	scan.record("10.0.0.3", scanStatusNotAxis, nil, map[string]interface{}{"ip": "10.0.0.3", "status": scanStatusNotAxis})
+
+	snapshot := scan.Snapshot()
+	if snapshot.ScannedCount != 3 || snapshot.Excluded != 1 {
+		t.Errorf("scanned %d with %d excluded, want 3 with 1", snapshot.ScannedCount, snapshot.Excluded)
+	}
+	if snapshot.AliveHosts != 1 || len(snapshot.NonCameras) != 1 || snapshot.DevicesFound != 1 {
+		t.Errorf("excluded device reported: %d alive hosts, %d non-cameras, %d devices",
+			snapshot.AliveHosts, len(snapshot.NonCameras), snapshot.DevicesFound)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
@@ -9,6 +10,7 @@ import (
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -18,8 +20,9 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
-	"sync"
 	"time"
+
+	"anava-camera-extension/pkg/common"
 )
 
 // Request represents incoming proxy request
@@ -29,6 +32,8 @@ type ProxyRequest struct {
 	Username string                 `json:"username"`
 	Password string                 `json:"password"`
 	Body     map[string]interface{} `json:"body,omitempty"`
+	// CredentialID refers to a vault credential set used instead of Username/Password
+	CredentialID string `json:"credentialId,omitempty"`
 }
 
 // Response represents proxy response
@@ -36,6 +41,10 @@ type ProxyResponse struct {
 	Status int                    `json:"status,omitempty"`
 	Data   map[string]interface{} `json:"data,omitempty"`
 	Error  string                 `json:"error,omitempty"`
+	// Certificate presented by the camera (HTTPS only)
+	Certificate *CertificateSummary `json:"certificate,omitempty"`
+
+	challenges []string // WWW-Authenticate headers of a 401
 }
 
 var (
@@ -43,77 +52,14 @@ var (
 	uploadClient *http.Client // Upload requests (3 minute timeout)
 	logger       *log.Logger
 	certStore    *CertificateStore
-)
-
-// CertificateStore manages certificate fingerprints for known cameras
-type CertificateStore struct {
-	mu           sync.RWMutex
-	fingerprints map[string]string // host -> SHA256 fingerprint
-	filePath     string
-}
-
-// NewCertificateStore creates a new certificate store
-func NewCertificateStore(filePath string) *CertificateStore {
-	store := &CertificateStore{
-		fingerprints: make(map[string]string),
-		filePath:     filePath,
-	}
-	store.load()
-	return store
-}
 
-// load reads saved fingerprints from disk
-func (cs *CertificateStore) load() {
-	data, err := os.ReadFile(cs.filePath)
-	if err != nil {
-		// File doesn't exist yet - that's okay
-		return
-	}
-
-	var fingerprints map[string]string
-	if err := json.Unmarshal(data, &fingerprints); err != nil {
-		logger.Printf("Warning: Failed to load certificate store: %v", err)
-		return
-	}
-
-	cs.mu.Lock()
-	cs.fingerprints = fingerprints
-	cs.mu.Unlock()
-
-	logger.Printf("Loaded %d certificate fingerprints", len(fingerprints))
-}
-
-// save writes fingerprints to disk
-func (cs *CertificateStore) save() {
-	cs.mu.RLock()
-	data, err := json.MarshalIndent(cs.fingerprints, "", "  ")
-	cs.mu.RUnlock()
-
-	if err != nil {
-		logger.Printf("Error marshaling certificate store: %v", err)
-		return
-	}
-
-	if err := os.WriteFile(cs.filePath, data, 0600); err != nil {
-		logger.Printf("Error saving certificate store: %v", err)
-	}
-}
-
-// GetFingerprint returns the stored fingerprint for a host
-func (cs *CertificateStore) GetFingerprint(host string) (string, bool) {
-	cs.mu.RLock()
-	defer cs.mu.RUnlock()
-	fp, ok := cs.fingerprints[host]
-	return fp, ok
-}
+	// certPinPolicy comes from connector-config.json like the local connector's, since
+	// both use the same fingerprints file (default: warn)
+	certPinPolicy = common.PinPolicyWarn
 
-// SetFingerprint stores a fingerprint for a host
-func (cs *CertificateStore) SetFingerprint(host, fingerprint string) {
-	cs.mu.Lock()
-	cs.fingerprints[host] = fingerprint
-	cs.mu.Unlock()
-	cs.save()
-}
+	credentialVault *common.CredentialVault // Encrypted camera credentials (shared with local connector)
+	cameraTLS       *common.CameraTLS       // Chain validation vs pinning per camera (nil = pin all)
+)
 
 // calculateCertFingerprint returns SHA256 fingerprint of certificate
 func calculateCertFingerprint(cert *x509.Certificate) string {
@@ -175,7 +121,10 @@ var allowedOrigins = map[string]bool{
 	"chrome-extension://ojhdgnojgelfiejpgipjddfddgefdpfa": true, // Extension ID (from install script)
 }
 
-func init() {
+// initServer opens the log, certificate store, credential vault and camera TLS config
+// under $HOME and creates the HTTP clients. Called from main rather than init so tests
+// can point HOME at a temporary directory first
+func initServer() {
 	// Setup logging
 	logDir := filepath.Join(os.Getenv("HOME"), "Library", "Logs")
 	os.MkdirAll(logDir, 0755)
@@ -195,48 +144,34 @@ func init() {
 	os.MkdirAll(certStoreDir, 0700)
 	certStoreFile := filepath.Join(certStoreDir, "certificate-fingerprints.json")
 	certStore = NewCertificateStore(certStoreFile)
-
-	// Create TLS config with certificate validation (shared by both clients)
-	tlsConfig := &tls.Config{
-		// SECURITY: Still accept self-signed, but we'll validate fingerprints
-		InsecureSkipVerify: true,
-		// Callback to validate certificate fingerprints
-		VerifyConnection: func(cs tls.ConnectionState) error {
-			if len(cs.PeerCertificates) == 0 {
-				return fmt.Errorf("no peer certificates")
+	if configStorage, err := common.NewConfigStorage(); err == nil {
+		if config, err := configStorage.Load(); err == nil {
+			policy, err := common.ParsePinPolicy(config.CertPinPolicy)
+			if err != nil {
+				logger.Printf("Warning: %v, using %s", err, common.PinPolicyWarn)
+				policy = common.PinPolicyWarn
 			}
+			certPinPolicy = policy
+		}
+	}
+	logger.Printf("Certificate pin policy: %s", certPinPolicy)
 
-			// Get the leaf certificate (server's cert)
-			cert := cs.PeerCertificates[0]
-			host := cs.ServerName
-			currentFingerprint := calculateCertFingerprint(cert)
-
-			// Check if we've seen this host before
-			if storedFingerprint, exists := certStore.GetFingerprint(host); exists {
-				// We've seen this host - verify fingerprint matches
-				if storedFingerprint != currentFingerprint {
-					// SECURITY ALERT: Certificate changed!
-					logger.Printf("🚨 SECURITY ALERT: Certificate changed for %s", host)
-					logger.Printf("   Stored fingerprint: %s", storedFingerprint)
-					logger.Printf("   Current fingerprint: %s", currentFingerprint)
-					logger.Printf("   This could indicate a Man-in-the-Middle attack!")
-
-					// For now, we'll log but allow (to prevent breaking deployments)
-					// In production, consider returning an error here
-					// return fmt.Errorf("certificate fingerprint mismatch for %s", host)
-				} else {
-					logger.Printf("✓ Certificate validated for %s (fingerprint matches)", host)
-				}
-			} else {
-				// First time seeing this host - store fingerprint
-				logger.Printf("📌 Pinning certificate for new host: %s", host)
-				logger.Printf("   Fingerprint: %s", currentFingerprint)
-				certStore.SetFingerprint(host, currentFingerprint)
-			}
+	// SECURITY: Open encrypted credential vault so the browser can send credential IDs
+	credentialVault, err = common.NewCredentialVault()
+	if err != nil {
+		logger.Fatalf("Failed to open credential vault: %v", err)
+	}
+	if err := credentialVault.Unavailable(); err != nil {
+		logger.Printf("Warning: %v", err)
+	}
 
-			return nil
-		},
+	// Chain validation vs pinning per camera comes from camera-tls.json (default: pin all),
+	// shared with the local connector
+	cameraTLS, err = common.LoadCameraTLS()
+	if err != nil {
+		logger.Fatalf("Failed to load camera TLS config: %v", err)
 	}
+	logger.Printf("Camera TLS: %s", cameraTLS)
 
 	// CRITICAL FIX: Create dialer that lets OS choose the best interface
 	// This ensures we use the interface that can actually reach 192.168.x.x networks
@@ -249,8 +184,8 @@ func init() {
 	// Create HTTP client for regular camera requests (30 second timeout)
 	client = &http.Client{
 		Transport: &http.Transport{
-			DialContext:     dialer.DialContext,
-			TLSClientConfig: tlsConfig,
+			DialContext:    dialer.DialContext,
+			DialTLSContext: dialCameraTLS(dialer),
 		},
 		Timeout: 30 * time.Second,
 	}
@@ -259,14 +194,122 @@ func init() {
 	// ACAP files can be several MB and cameras take time to process uploads
 	uploadClient = &http.Client{
 		Transport: &http.Transport{
-			DialContext:     dialer.DialContext,
-			TLSClientConfig: tlsConfig,
+			DialContext:    dialer.DialContext,
+			DialTLSContext: dialCameraTLS(dialer),
 		},
 		Timeout: 300 * time.Second, // 5 minutes for large file uploads (matches Electron installer)
 	}
 	logger.Printf("Initialized HTTP clients: regular (30s timeout), upload (300s timeout)")
 }
 
+// dialCameraTLS returns a TLS dial function that hands the actually dialed
+// ip:port to verifyCertificate, so pins don't depend on the SNI name
+func dialCameraTLS(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		rawConn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		serverName, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			serverName = addr
+		}
+
+		remoteAddr := rawConn.RemoteAddr().String()
+		var remoteIP net.IP
+		if tcpAddr, ok := rawConn.RemoteAddr().(*net.TCPAddr); ok {
+			remoteIP = tcpAddr.IP
+		}
+
+		config := &tls.Config{
+			ServerName: serverName,
+			// SECURITY: Still accept self-signed, but we'll validate fingerprints
+			InsecureSkipVerify: true,
+			// Callback to validate certificate fingerprints
+			VerifyConnection: func(cs tls.ConnectionState) error {
+				return verifyCertificate(remoteAddr, addr, cs)
+			},
+		}
+		if clientCert := cameraTLS.ClientCertificate(serverName, remoteIP); clientCert != nil {
+			// mTLS: device management interfaces that require a client certificate
+			config.Certificates = []tls.Certificate{*clientCert}
+		}
+		if cameraTLS.Mode(serverName, remoteIP) == common.TrustModeChain {
+			// Enterprise PKI / device ID certificates: full chain and hostname validation
+			config.InsecureSkipVerify = false
+			config.RootCAs = cameraTLS.Roots()
+		}
+
+		tlsConn := tls.Client(rawConn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+// verifyCertificate validates TLS certificate fingerprints
+// addr is the dialed ip:port (the pin key); host is the host:port from the request URL
+func verifyCertificate(addr, host string, cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("no peer certificates")
+	}
+
+	// Get the leaf certificate (server's cert)
+	cert := cs.PeerCertificates[0]
+	currentFingerprint := calculateCertFingerprint(cert)
+
+	label := addr
+	if pinKey(host) != pinKey(addr) {
+		label = fmt.Sprintf("%s (%s)", addr, host)
+	}
+
+	if len(cs.VerifiedChains) > 0 {
+		// Chain-validated (TrustModeChain): track the certificate for expiry, don't pin it
+		certStore.Observe(addr, host, cert)
+		return nil
+	}
+
+	// Same policy logic as the local connector (pkg/proxy); rejected certificates
+	// are approved from the local connector (/certs/accept)
+	storedFingerprint, exists := certStore.Lookup(addr, host)
+	switch {
+	case !exists && certPinPolicy == common.PinPolicyPreApproved:
+		logger.Printf("🚫 Rejecting certificate for %s: not pre-approved", label)
+		logger.Printf("   Fingerprint: %s", currentFingerprint)
+		return fmt.Errorf("%w: %s is not pre-approved", common.ErrCertificateMismatch, label)
+
+	case !exists:
+		// First time seeing this host - store fingerprint
+		logger.Printf("📌 Pinning certificate for new host: %s", label)
+		logger.Printf("   Fingerprint: %s", currentFingerprint)
+		certStore.Pin(addr, host, cert)
+
+	case storedFingerprint != currentFingerprint:
+		// SECURITY ALERT: Certificate changed!
+		logger.Printf("🚨 SECURITY ALERT: Certificate changed for %s", label)
+		logger.Printf("   Stored fingerprint: %s", storedFingerprint)
+		logger.Printf("   Current fingerprint: %s", currentFingerprint)
+		logger.Printf("   This could indicate a Man-in-the-Middle attack!")
+
+		if certPinPolicy == common.PinPolicyWarn {
+			// Warn-only: log but allow (to prevent breaking deployments)
+			return nil
+		}
+		logger.Printf("   Blocked by %s policy - accept the new fingerprint if the certificate was rotated", certPinPolicy)
+		return fmt.Errorf("%w for %s", common.ErrCertificateMismatch, label)
+
+	default:
+		logger.Printf("✓ Certificate validated for %s (fingerprint matches)", label)
+		// Re-keys migrated hostname pins and keeps aliases current
+		certStore.Pin(addr, host, cert)
+	}
+
+	return nil
+}
+
 // isOriginAllowed checks if the request origin is in the whitelist
 func isOriginAllowed(origin string) bool {
 	// Empty origin = same-origin or localhost direct access (allow for testing)
@@ -284,6 +327,19 @@ func isOriginAllowed(origin string) bool {
 	return allowedOrigins[origin]
 }
 
+// requireTrustedOrigin limits vault credentials to allow-listed origins
+// Unlike isOriginAllowed it doesn't accept every chrome-extension:// origin; requests
+// without an Origin header come from local tools, not web pages
+func requireTrustedOrigin(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || allowedOrigins[origin] {
+		return true
+	}
+	logger.Printf("SECURITY: Blocked %s %s from untrusted origin: %s", r.Method, r.URL.Path, origin)
+	http.Error(w, "Forbidden: Origin not allowed", http.StatusForbidden)
+	return false
+}
+
 // setCORSHeaders sets appropriate CORS headers based on origin validation
 func setCORSHeaders(w http.ResponseWriter, r *http.Request) bool {
 	origin := r.Header.Get("Origin")
@@ -303,7 +359,7 @@ func setCORSHeaders(w http.ResponseWriter, r *http.Request) bool {
 		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
 	}
 
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
@@ -312,7 +368,7 @@ func setCORSHeaders(w http.ResponseWriter, r *http.Request) bool {
 
 // tryUnauthenticatedRequest makes ONE request without auth (3 second timeout)
 // This is Step 1 of the Electron pattern - quickly detect non-cameras
-func tryUnauthenticatedRequest(req *ProxyRequest) (ProxyResponse, error) {
+func tryUnauthenticatedRequest(ctx context.Context, req *ProxyRequest) (ProxyResponse, error) {
 	logger.Println("Trying unauthenticated request (3s timeout)")
 
 	var bodyReader io.Reader
@@ -329,7 +385,7 @@ func tryUnauthenticatedRequest(req *ProxyRequest) (ProxyResponse, error) {
 		logger.Println("WARNING: No body to send (req.Body is nil or empty)")
 	}
 
-	httpReq, err := http.NewRequest(req.Method, req.URL, bodyReader)
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, req.URL, bodyReader)
 	if err != nil {
 		return ProxyResponse{}, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -378,13 +434,21 @@ func isConnectionRefusedError(err error) bool {
 }
 
 func main() {
+	initServer()
+
 	// Start HTTP server on localhost only (Chrome can access localhost)
 	http.HandleFunc("/proxy", handleProxyRequest)
 	http.HandleFunc("/health", handleHealth)
 	http.HandleFunc("/upload-acap", handleUploadAcap)
 	http.HandleFunc("/upload-license", handleUploadLicense)
-	http.HandleFunc("/scan-network", handleScanNetwork)      // NEW: Bulk scan API
-	http.HandleFunc("/scan-results", handleScanResults)      // NEW: WebSocket progress
+	http.HandleFunc("/scan-network", handleScanNetwork) // NEW: Bulk scan API
+	http.HandleFunc("/scan-results", handleScanResults) // NEW: WebSocket progress
+	http.HandleFunc("/scan-network/pause", handleScanControl("pause"))
+	http.HandleFunc("/scan-network/resume", handleScanControl("resume"))
+	http.HandleFunc("/scan-network/cancel", handleScanControl("cancel"))
+	http.HandleFunc("/scan-network/status", handleScanStatus)
+	http.HandleFunc("/credentials", handleCredentials)
+	http.HandleFunc("/credentials/rotate", handleRotateCredential)
 
 	port := "9876"
 	addr := "127.0.0.1:" + port
@@ -433,6 +497,18 @@ func handleProxyRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Vault credentials are only for allow-listed origins, not any extension
+	if req.CredentialID != "" && !requireTrustedOrigin(w, r) {
+		return
+	}
+
+	// Fill in username/password from the vault when a credential ID is given
+	if err := credentialVault.ResolveFor(req.CredentialID, req.URL, &req.Username, &req.Password); err != nil {
+		logger.Printf("Failed to resolve credential: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// SECURITY: Sanitize credentials in logs
 	logger.Printf("Proxying request: %s %s (user: %s)", req.Method, req.URL, sanitizeCredential(req.Username))
 	if req.Body != nil && len(req.Body) > 0 {
@@ -443,7 +519,7 @@ func handleProxyRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Make request to camera (NOT affected by Chrome sandbox)
-	resp, err := makeCameraRequest(&req)
+	resp, err := makeCameraRequest(r.Context(), &req)
 	if err != nil {
 		logger.Printf("Camera request failed: %v", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -460,12 +536,13 @@ func handleProxyRequest(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-func makeCameraRequest(req *ProxyRequest) (ProxyResponse, error) {
+// makeCameraRequest proxies req to the camera, stopping as soon as ctx is cancelled
+func makeCameraRequest(ctx context.Context, req *ProxyRequest) (ProxyResponse, error) {
 	// CRITICAL: Follow Electron pattern exactly
 	// Step 1: Try ONE unauthenticated request first (3 second timeout)
 	logger.Println("Step 1: Testing connection without authentication")
 
-	resp, err := tryUnauthenticatedRequest(req)
+	resp, err := tryUnauthenticatedRequest(ctx, req)
 
 	// On timeout/connection refused, return immediately (not a camera)
 	if err != nil {
@@ -497,29 +574,41 @@ func makeCameraRequest(req *ProxyRequest) (ProxyResponse, error) {
 	if isHTTPS {
 		// HTTPS: Try Basic first, then Digest
 		logger.Println("HTTPS detected: Trying Basic Auth first")
-		resp, err := tryBasicAuth(req)
+		resp, err := tryBasicAuth(ctx, req)
 		if err == nil && resp.Status == 200 {
 			logger.Println("Basic Auth succeeded")
 			return resp, nil
 		}
+		if ctx.Err() != nil {
+			return ProxyResponse{}, ctx.Err()
+		}
 
 		logger.Println("Basic Auth failed, trying Digest Auth")
-		return tryDigestAuth(req)
+		return tryDigestAuth(ctx, req)
 	} else {
 		// HTTP: Try Digest first, then Basic
 		logger.Println("HTTP detected: Trying Digest Auth first")
-		resp, err := tryDigestAuth(req)
+		resp, err := tryDigestAuth(ctx, req)
 		if err == nil && resp.Status == 200 {
 			logger.Println("Digest Auth succeeded")
 			return resp, nil
 		}
+		if ctx.Err() != nil {
+			return ProxyResponse{}, ctx.Err()
+		}
+
+		if req.CredentialID != "" {
+			// SECURITY: Never send vault credentials in the clear
+			logger.Println("Vault credential over plain HTTP: not falling back to Basic Auth")
+			return resp, err
+		}
 
 		logger.Println("Digest Auth failed, trying Basic Auth")
-		return tryBasicAuth(req)
+		return tryBasicAuth(ctx, req)
 	}
 }
 
-func tryBasicAuth(req *ProxyRequest) (ProxyResponse, error) {
+func tryBasicAuth(ctx context.Context, req *ProxyRequest) (ProxyResponse, error) {
 	logger.Println("Trying Basic authentication")
 
 	var bodyReader io.Reader
@@ -536,7 +625,7 @@ func tryBasicAuth(req *ProxyRequest) (ProxyResponse, error) {
 		logger.Println("Basic Auth - WARNING: No body to send")
 	}
 
-	httpReq, err := http.NewRequest(req.Method, req.URL, bodyReader)
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, req.URL, bodyReader)
 	if err != nil {
 		return ProxyResponse{}, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -559,7 +648,10 @@ func tryBasicAuth(req *ProxyRequest) (ProxyResponse, error) {
 	return parseResponse(httpResp)
 }
 
-func tryDigestAuth(req *ProxyRequest) (ProxyResponse, error) {
+// errNoDigestChallenge means the device answered 401 without a usable Digest challenge
+var errNoDigestChallenge = errors.New("no usable Digest challenge")
+
+func tryDigestAuth(ctx context.Context, req *ProxyRequest) (ProxyResponse, error) {
 	logger.Println("Trying Digest authentication")
 
 	// First request to get challenge (send body for Axis cameras that process it)
@@ -575,7 +667,7 @@ func tryDigestAuth(req *ProxyRequest) (ProxyResponse, error) {
 		logger.Printf("Digest Auth - Challenge request with body (%d bytes)", len(bodyBytes))
 	}
 
-	httpReq, err := http.NewRequest(req.Method, req.URL, bodyReader)
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, req.URL, bodyReader)
 	if err != nil {
 		return ProxyResponse{}, fmt.Errorf("failed to create initial request: %w", err)
 	}
@@ -599,7 +691,7 @@ func tryDigestAuth(req *ProxyRequest) (ProxyResponse, error) {
 	// Parse WWW-Authenticate header
 	authHeader := httpResp.Header.Get("WWW-Authenticate")
 	if authHeader == "" {
-		return ProxyResponse{}, fmt.Errorf("no WWW-Authenticate header in response")
+		return ProxyResponse{}, fmt.Errorf("%w: no WWW-Authenticate header in response", errNoDigestChallenge)
 	}
 
 	logger.Printf("WWW-Authenticate header: %s", authHeader)
@@ -607,7 +699,7 @@ func tryDigestAuth(req *ProxyRequest) (ProxyResponse, error) {
 	// Parse Digest challenge
 	challenge, err := parseDigestChallenge(authHeader)
 	if err != nil {
-		return ProxyResponse{}, fmt.Errorf("failed to parse Digest challenge: %w", err)
+		return ProxyResponse{}, fmt.Errorf("%w: %v", errNoDigestChallenge, err)
 	}
 
 	// Calculate Digest response
@@ -629,7 +721,7 @@ func tryDigestAuth(req *ProxyRequest) (ProxyResponse, error) {
 		logger.Println("Digest Auth - WARNING: No body to send in authenticated request")
 	}
 
-	httpReq2, err := http.NewRequest(req.Method, req.URL, bodyReader)
+	httpReq2, err := http.NewRequestWithContext(ctx, req.Method, req.URL, bodyReader)
 	if err != nil {
 		return ProxyResponse{}, fmt.Errorf("failed to create authenticated request: %w", err)
 	}
@@ -754,8 +846,13 @@ func parseResponse(httpResp *http.Response) (ProxyResponse, error) {
 	logger.Printf("Response status: %d, body length: %d bytes", httpResp.StatusCode, len(bodyBytes))
 
 	resp := ProxyResponse{
-		Status: httpResp.StatusCode,
-		Data:   make(map[string]interface{}),
+		Status:     httpResp.StatusCode,
+		Data:       make(map[string]interface{}),
+		challenges: httpResp.Header.Values("WWW-Authenticate"),
+	}
+
+	if httpResp.TLS != nil && len(httpResp.TLS.PeerCertificates) > 0 {
+		resp.Certificate = newCertificateSummary(httpResp.TLS.PeerCertificates[0], time.Now())
 	}
 
 	if len(bodyBytes) > 0 {
@@ -797,10 +894,11 @@ func handleUploadAcap(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var payload struct {
-		URL      string `json:"url"`
-		Username string `json:"username"`
-		Password string `json:"password"`
-		AcapURL  string `json:"acapUrl"`
+		URL          string `json:"url"`
+		Username     string `json:"username"`
+		Password     string `json:"password"`
+		CredentialID string `json:"credentialId"`
+		AcapURL      string `json:"acapUrl"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -809,10 +907,26 @@ func handleUploadAcap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if payload.CredentialID != "" && !requireTrustedOrigin(w, r) {
+		return
+	}
+	if err := credentialVault.ResolveFor(payload.CredentialID, payload.URL, &payload.Username, &payload.Password); err != nil {
+		logger.Printf("Failed to resolve credential: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	logger.Printf("ACAP upload started: %s -> camera", payload.AcapURL)
 
-	// Download ACAP file from GitHub
-	acapResp, err := http.Get(payload.AcapURL)
+	// Download ACAP file from GitHub (abandoned if the browser goes away)
+	acapReq, err := http.NewRequestWithContext(r.Context(), "GET", payload.AcapURL, nil)
+	if err != nil {
+		logger.Printf("Invalid ACAP URL: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid ACAP URL: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	acapResp, err := http.DefaultClient.Do(acapReq)
 	if err != nil {
 		logger.Printf("Failed to download ACAP: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to download ACAP: %v", err), http.StatusInternalServerError)
@@ -851,7 +965,7 @@ func handleUploadAcap(w http.ResponseWriter, r *http.Request) {
 	bodyBytes := buf.Bytes()
 
 	// Upload to camera with Digest Auth
-	httpReq, err := http.NewRequest("POST", payload.URL, bytes.NewReader(bodyBytes))
+	httpReq, err := http.NewRequestWithContext(r.Context(), "POST", payload.URL, bytes.NewReader(bodyBytes))
 	if err != nil {
 		logger.Printf("Failed to create upload request: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to create request: %v", err), http.StatusInternalServerError)
@@ -905,10 +1019,11 @@ func handleUploadLicense(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var payload struct {
-		URL        string `json:"url"`
-		Username   string `json:"username"`
-		Password   string `json:"password"`
-		LicenseXML string `json:"licenseXML"`
+		URL          string `json:"url"`
+		Username     string `json:"username"`
+		Password     string `json:"password"`
+		CredentialID string `json:"credentialId"`
+		LicenseXML   string `json:"licenseXML"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -917,6 +1032,15 @@ func handleUploadLicense(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if payload.CredentialID != "" && !requireTrustedOrigin(w, r) {
+		return
+	}
+	if err := credentialVault.ResolveFor(payload.CredentialID, payload.URL, &payload.Username, &payload.Password); err != nil {
+		logger.Printf("Failed to resolve credential: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	logger.Printf("License upload started (%d bytes)", len(payload.LicenseXML))
 
 	// Create multipart form-data with license XML
@@ -941,7 +1065,7 @@ func handleUploadLicense(w http.ResponseWriter, r *http.Request) {
 	bodyBytes := buf.Bytes()
 
 	// Upload to camera with Digest Auth
-	httpReq, err := http.NewRequest("POST", payload.URL, bytes.NewReader(bodyBytes))
+	httpReq, err := http.NewRequestWithContext(r.Context(), "POST", payload.URL, bytes.NewReader(bodyBytes))
 	if err != nil {
 		logger.Printf("Failed to create upload request: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to create request: %v", err), http.StatusInternalServerError)
@@ -987,6 +1111,7 @@ func handleUploadLicense(w http.ResponseWriter, r *http.Request) {
 }
 
 // makeAuthenticatedRequest handles Digest auth for camera requests
+// Follow-up requests inherit req's context so they stop when the caller goes away
 func makeAuthenticatedRequest(req *http.Request, username, password string) (*http.Response, error) {
 	// First request to get challenge
 	resp, err := client.Do(req)
@@ -1012,7 +1137,7 @@ func makeAuthenticatedRequest(req *http.Request, username, password string) (*ht
 		bodyBytes, _ = io.ReadAll(req.Body)
 	}
 
-	req2, err := http.NewRequest(req.Method, req.URL.String(), bytes.NewReader(bodyBytes))
+	req2, err := http.NewRequestWithContext(req.Context(), req.Method, req.URL.String(), bytes.NewReader(bodyBytes))
 	if err != nil {
 		return nil, err
 	}
@@ -1043,11 +1168,12 @@ func makeAuthenticatedRequestWithBody(req *http.Request, username, password stri
 
 // makeAuthenticatedRequestWithBodyAndClient handles Digest auth with custom HTTP client
 // Allows using uploadClient for long-running uploads (3 min timeout)
+// All requests use req's context, so an abandoned upload stops immediately
 func makeAuthenticatedRequestWithBodyAndClient(req *http.Request, username, password string, bodyBytes []byte, httpClient *http.Client) (*http.Response, error) {
 	logger.Printf("Attempting authenticated request (body size: %d bytes)", len(bodyBytes))
 
 	// First request to get challenge (send minimal request without body to save bandwidth)
-	req1, err := http.NewRequest(req.Method, req.URL.String(), nil)
+	req1, err := http.NewRequestWithContext(req.Context(), req.Method, req.URL.String(), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create challenge request: %w", err)
 	}
@@ -1062,7 +1188,7 @@ func makeAuthenticatedRequestWithBodyAndClient(req *http.Request, username, pass
 		// Close this response and make the real request with body
 		resp.Body.Close()
 
-		req2, err := http.NewRequest(req.Method, req.URL.String(), bytes.NewReader(bodyBytes))
+		req2, err := http.NewRequestWithContext(req.Context(), req.Method, req.URL.String(), bytes.NewReader(bodyBytes))
 		if err != nil {
 			return nil, err
 		}
@@ -1092,7 +1218,7 @@ func makeAuthenticatedRequestWithBodyAndClient(req *http.Request, username, pass
 	logger.Printf("Parsed challenge: realm=%s, nonce=%s", challenge.Realm, challenge.Nonce[:10]+"...")
 
 	// Create authenticated request with full body
-	req2, err := http.NewRequest(req.Method, req.URL.String(), bytes.NewReader(bodyBytes))
+	req2, err := http.NewRequestWithContext(req.Context(), req.Method, req.URL.String(), bytes.NewReader(bodyBytes))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create authenticated request: %w", err)
 	}
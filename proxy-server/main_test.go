@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"anava-camera-extension/pkg/common"
+)
+
+// TestMain runs the tests against a temporary HOME so the log, certificate store,
+// credential vault and its key never touch the developer's real files
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "anava-proxy-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to create test home:", err)
+		os.Exit(1)
+	}
+	os.Setenv("HOME", home)
+	// A passphrase keeps the vault key out of the real Keychain / keyring
+	os.Setenv("ANAVA_VAULT_PASSPHRASE", "test-passphrase")
+	initServer()
+
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+// newTestCertificate returns a self-signed certificate for commonName
+func newTestCertificate(t *testing.T, commonName string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestVerifyCertificatePolicy(t *testing.T) {
+	pinned := newTestCertificate(t, "pinned")
+	rotated := newTestCertificate(t, "rotated")
+
+	tests := []struct {
+		policy  common.PinPolicy
+		pin     *x509.Certificate // Pinned before the handshake, nil for a new host
+		present *x509.Certificate
+		wantErr bool
+	}{
+		{common.PinPolicyWarn, nil, pinned, false},
+		{common.PinPolicyWarn, pinned, pinned, false},
+		{common.PinPolicyWarn, pinned, rotated, false},
+		{common.PinPolicyTOFU, nil, pinned, false},
+		{common.PinPolicyTOFU, pinned, pinned, false},
+		{common.PinPolicyTOFU, pinned, rotated, true},
+		{common.PinPolicyPreApproved, nil, pinned, true},
+		{common.PinPolicyPreApproved, pinned, pinned, false},
+		{common.PinPolicyPreApproved, pinned, rotated, true},
+	}
+
+	original := certPinPolicy
+	t.Cleanup(func() { certPinPolicy = original })
+
+	for i, tt := range tests {
+		name := fmt.Sprintf("%s/pinned=%v/%s", tt.policy, tt.pin != nil, tt.present.Subject.CommonName)
+		t.Run(name, func(t *testing.T) {
+			certPinPolicy = tt.policy
+			addr := fmt.Sprintf("10.99.0.%d:443", i+1)
+			if tt.pin != nil {
+				certStore.Pin(addr, addr, tt.pin)
+			}
+
+			err := verifyCertificate(addr, addr, tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.present}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyCertificate err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, common.ErrCertificateMismatch) {
+				t.Errorf("err = %v, want ErrCertificateMismatch", err)
+			}
+
+			// A rejected certificate must never replace or create a pin
+			fingerprint, exists := certStore.Lookup(addr, addr)
+			switch {
+			case tt.wantErr && tt.pin == nil && exists:
+				t.Error("rejected certificate was pinned")
+			case tt.wantErr && tt.pin != nil && fingerprint != calculateCertFingerprint(tt.pin):
+				t.Error("rejected certificate replaced the pin")
+			}
+		})
+	}
+}
@@ -1,45 +1,309 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"anava-camera-extension/pkg/common"
 )
 
 // ScanRequest represents a bulk network scan request
 type ScanRequest struct {
-	IPs      []string `json:"ips"`
-	Username string   `json:"username"`
-	Password string   `json:"password"`
+	IPs          []string `json:"ips,omitempty"`
+	Targets      []string `json:"targets,omitempty"`      // IPs, CIDRs, dash ranges or hostnames, expanded server-side
+	Exclude      []string `json:"exclude,omitempty"`      // Same syntax as Targets
+	Endpoints    []string `json:"endpoints,omitempty"`    // scheme:port combinations tried in order (default https:443)
+	DeviceTypes  []string `json:"device_types,omitempty"` // Axis device classes to report (default: all)
+	Username     string   `json:"username"`
+	Password     string   `json:"password"`
+	CredentialID string   `json:"credential_id,omitempty"` // Vault credential used instead of username/password
+	// Further credential sets tried per host, in order, after username/password or credential_id
+	Credentials []ScanCredential `json:"credentials,omitempty"`
+
+	// Concurrency control (see AdaptiveScanConfig); unset fields come from the intensity preset
+	Intensity         string  `json:"intensity,omitempty"` // conservative, balanced (default) or aggressive
+	MinWorkers        int     `json:"min_workers,omitempty"`
+	MaxWorkers        int     `json:"max_workers,omitempty"`
+	TargetErrorRate   float64 `json:"target_error_rate,omitempty"`
+	MaxErrorRate      float64 `json:"max_error_rate,omitempty"`
+	InterBatchDelayMs int     `json:"inter_batch_delay_ms,omitempty"`
+
+	ProbeTimeoutMs int `json:"probe_timeout_ms,omitempty"` // TCP pre-probe connect timeout (default 500)
+}
+
+// ScanCredential is one credential set tried during a scan: inline or a vault reference
+type ScanCredential struct {
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	CredentialID string `json:"credential_id,omitempty"` // Vault credential used instead of username/password
+}
+
+// maxScanCredentials limits how many passwords a scan tries per host (lockout protection)
+const maxScanCredentials = 10
+
+// scanCredential is a resolved ScanCredential
+type scanCredential struct {
+	index        int    // Position in the request's credential order
+	credentialID string // Vault ID, if the set came from the vault
+	username     string
+	password     string
+	vault        *common.CredentialSet // Vault entry, which limits the set to its allowed hosts
+}
+
+// usesCredentialVault reports whether any of the request's credential sets refers to the vault
+func usesCredentialVault(req *ScanRequest) bool {
+	if req.CredentialID != "" {
+		return true
+	}
+	for _, set := range req.Credentials {
+		if set.CredentialID != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveScanCredentials returns the request's credential sets in order, resolving vault references
+// The legacy username/password/credential_id fields form the first set; with no
+// sets at all a single empty set keeps the old behaviour
+func resolveScanCredentials(req *ScanRequest) ([]scanCredential, error) {
+	sets := req.Credentials
+	if req.Username != "" || req.Password != "" || req.CredentialID != "" || len(sets) == 0 {
+		legacy := ScanCredential{Username: req.Username, Password: req.Password, CredentialID: req.CredentialID}
+		sets = append([]ScanCredential{legacy}, sets...)
+	}
+	if len(sets) > maxScanCredentials {
+		return nil, fmt.Errorf("too many credential sets: %d (max %d)", len(sets), maxScanCredentials)
+	}
+
+	credentials := make([]scanCredential, 0, len(sets))
+	for i, set := range sets {
+		cred := scanCredential{
+			index:        i,
+			credentialID: set.CredentialID,
+			username:     set.Username,
+			password:     set.Password,
+		}
+		if set.CredentialID != "" {
+			if err := credentialVault.Unavailable(); err != nil {
+				return nil, err
+			}
+			vaultCred, ok := credentialVault.Get(set.CredentialID)
+			if !ok {
+				return nil, fmt.Errorf("credential set %d: credential not found: %s", i, set.CredentialID)
+			}
+			if len(vaultCred.AllowedHosts) == 0 {
+				return nil, fmt.Errorf("credential set %d: credential %s has no allowed hosts", i, set.CredentialID)
+			}
+			cred.username, cred.password, cred.vault = vaultCred.Username, vaultCred.Password, &vaultCred
+		}
+		credentials = append(credentials, cred)
+	}
+	return credentials, nil
+}
+
+// credentialsFor returns the credential sets that may be sent to ip, in order
+// With none allowed the host is only probed without credentials
+func credentialsFor(credentials []scanCredential, ip string) []scanCredential {
+	var allowed []scanCredential
+	for _, cred := range credentials {
+		if cred.vault == nil || cred.vault.AllowsHost(ip) {
+			allowed = append(allowed, cred)
+		}
+	}
+	if len(allowed) == 0 {
+		return []scanCredential{{index: -1}}
+	}
+	return allowed
+}
+
+// report identifies the credential set in scan results without the secret
+func (cred *scanCredential) report() map[string]interface{} {
+	report := map[string]interface{}{
+		"index":    cred.index,
+		"username": sanitizeCredential(cred.username),
+	}
+	if cred.credentialID != "" {
+		report["credentialId"] = cred.credentialID
+	}
+	return report
 }
 
 // ScanProgress represents real-time scan progress
 type ScanProgress struct {
-	ScanID        string                 `json:"scan_id"`
-	IP            string                 `json:"ip"`
-	Camera        map[string]interface{} `json:"camera,omitempty"`
-	Error         string                 `json:"error,omitempty"`
-	ScannedCount  int                    `json:"scanned_count"`
-	TotalIPs      int                    `json:"total_ips"`
-	CamerasFound  int                    `json:"cameras_found"`
-	PercentDone   float64                `json:"percent_done"`
-	IsComplete    bool                   `json:"is_complete"`
+	ScanID    string                 `json:"scan_id"`
+	IP        string                 `json:"ip"`
+	Status    string                 `json:"status,omitempty"`     // Result for IP (scanStatus*)
+	Camera    map[string]interface{} `json:"camera,omitempty"`     // Axis device of any reported class (see deviceType)
+	NonCamera map[string]interface{} `json:"non_camera,omitempty"` // Alive host that isn't a reported device
+	// Snapshot messages are sent first on connect and list every device found so far
+	Snapshot     bool                     `json:"snapshot,omitempty"`
+	Cameras      []map[string]interface{} `json:"cameras,omitempty"`
+	NonCameras   []map[string]interface{} `json:"non_cameras,omitempty"`
+	Error        string                   `json:"error,omitempty"`
+	ScannedCount int                      `json:"scanned_count"`
+	TotalIPs     int                      `json:"total_ips"`
+	CamerasFound int                      `json:"cameras_found"`
+	DevicesFound int                      `json:"devices_found"` // Axis devices of any reported class, cameras included
+	AuthFailed   int                      `json:"auth_failed"`   // Devices that rejected the scan credentials
+	AliveHosts   int                      `json:"alive_hosts"`   // Hosts that answered but aren't reported devices
+	Excluded     int                      `json:"excluded"`      // Axis devices left out by the device_types filter
+	PercentDone  float64                  `json:"percent_done"`
+	IsComplete   bool                     `json:"is_complete"`
+	State        string                   `json:"state,omitempty"` // Set on state changes and the final message
+}
+
+// Scan states reported by the control endpoints and the progress channel
+const (
+	scanStateScanning  = "scanning"
+	scanStatePaused    = "paused"
+	scanStateCancelled = "cancelled"
+	scanStateComplete  = "complete"
+)
+
+// ScanEndpoint is a scheme and port probed on every scanned IP
+type ScanEndpoint struct {
+	Scheme string
+	Port   int
+}
+
+// defaultScanEndpoints keeps the original behaviour: HTTPS on 443 only
+var defaultScanEndpoints = []ScanEndpoint{{Scheme: "https", Port: 443}}
+
+// String returns the endpoint as scheme:port
+func (e ScanEndpoint) String() string {
+	return fmt.Sprintf("%s:%d", e.Scheme, e.Port)
+}
+
+// BaseURL returns the endpoint's base URL for ip
+func (e ScanEndpoint) BaseURL(ip string) string {
+	return fmt.Sprintf("%s://%s", e.Scheme, net.JoinHostPort(ip, strconv.Itoa(e.Port)))
+}
+
+// parseScanEndpoints parses scheme:port specs; a bare scheme uses its default port
+func parseScanEndpoints(specs []string) ([]ScanEndpoint, error) {
+	if len(specs) == 0 {
+		return defaultScanEndpoints, nil
+	}
+
+	seen := make(map[ScanEndpoint]bool)
+	var endpoints []ScanEndpoint
+	for _, spec := range specs {
+		scheme, portStr, hasPort := strings.Cut(strings.ToLower(strings.TrimSpace(spec)), ":")
+
+		var endpoint ScanEndpoint
+		switch scheme {
+		case "https":
+			endpoint = ScanEndpoint{Scheme: scheme, Port: 443}
+		case "http":
+			endpoint = ScanEndpoint{Scheme: scheme, Port: 80}
+		default:
+			return nil, fmt.Errorf("invalid endpoint %q: scheme must be http or https", spec)
+		}
+
+		if hasPort {
+			port, err := strconv.Atoi(portStr)
+			if err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("invalid endpoint %q: bad port", spec)
+			}
+			endpoint.Port = port
+		}
+
+		if !seen[endpoint] {
+			seen[endpoint] = true
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints, nil
+}
+
+// Per-IP scan results
+const (
+	scanStatusCameraFound = "camera_found"
+	scanStatusDeviceFound = "device_found" // Axis device of another reported class
+	scanStatusExcluded    = "excluded"     // Axis device of a class outside device_types
+	scanStatusAuthFailed  = "auth_failed"
+	scanStatusNotAxis     = "not_axis"
+	scanStatusNoResponse  = "no_response"
+	scanStatusClosed      = "closed" // Host answered, but refused every camera port
+	scanStatusTLSError    = "tls_error"
+)
+
+// Axis device classes, derived from the product number by getDeviceType
+const (
+	deviceTypeCamera        = "camera"
+	deviceTypeEncoder       = "encoder"
+	deviceTypeSpeaker       = "speaker"
+	deviceTypeIntercom      = "intercom"
+	deviceTypeAccessControl = "access-control"
+	deviceTypeUnknown       = "unknown"
+)
+
+// parseDeviceTypes validates a device class filter; nil means every class
+func parseDeviceTypes(types []string) (map[string]bool, error) {
+	if len(types) == 0 {
+		return nil, nil
+	}
+
+	filter := make(map[string]bool)
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch t {
+		case deviceTypeCamera, deviceTypeEncoder, deviceTypeSpeaker,
+			deviceTypeIntercom, deviceTypeAccessControl, deviceTypeUnknown:
+			filter[t] = true
+		default:
+			return nil, fmt.Errorf("unknown device type: %s", t)
+		}
+	}
+	return filter, nil
+}
+
+// scanOptions are the per-scan settings shared by every worker
+type scanOptions struct {
+	endpoints    []ScanEndpoint
+	adaptive     AdaptiveScanConfig
+	probeTimeout time.Duration
+	deviceTypes  map[string]bool  // nil = report every class
+	credentials  []scanCredential // Tried in order on every host
 }
 
 // ActiveScan represents an in-progress scan
+// Progress is aggregated by the methods in hub.go; read it through Snapshot
 type ActiveScan struct {
-	ID           string
-	TotalIPs     int
-	ScannedCount int
-	CamerasFound int
-	ProgressChan chan ScanProgress
-	Clients      map[*websocket.Conn]bool
-	ClientsMu    sync.RWMutex
-	StartTime    time.Time
+	ID        string
+	TotalIPs  int
+	StartTime time.Time
+
+	mu           sync.Mutex
+	scannedCount int
+	camerasFound int
+	devicesFound int
+	authFailed   int
+	aliveHosts   int
+	excluded     int
+	devices      []map[string]interface{} // Replayed to late subscribers
+	nonCameras   []map[string]interface{}
+	clients      map[*scanClient]bool
+	finished     bool
+	final        ScanProgress
+
+	stateMu sync.Mutex
+	state   string
+	resume  chan struct{} // Closed on resume; nil unless paused
+	cancel  context.CancelFunc
 }
 
 var (
@@ -75,60 +339,118 @@ func handleScanNetwork(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(req.IPs) == 0 {
-		http.Error(w, "No IPs provided", http.StatusBadRequest)
+	// Vault credentials are only for allow-listed origins; check before any vault
+	// lookup so other origins can't probe which credential IDs exist
+	if usesCredentialVault(&req) && !requireTrustedOrigin(w, r) {
+		return
+	}
+
+	if len(req.IPs) == 0 && len(req.Targets) == 0 {
+		http.Error(w, "No IPs or targets provided", http.StatusBadRequest)
+		return
+	}
+
+	ips, excluded, err := expandScanTargets(r.Context(), append(req.IPs, req.Targets...), req.Exclude)
+	if err != nil {
+		logger.Printf("Invalid scan targets: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(ips) == 0 {
+		http.Error(w, "No IPs left to scan after exclusions", http.StatusBadRequest)
+		return
+	}
+
+	endpoints, err := parseScanEndpoints(req.Endpoints)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	adaptive, err := newAdaptiveScanConfig(&req, ips)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	probeTimeout, err := sweepTimeout(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deviceTypes, err := parseDeviceTypes(req.DeviceTypes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	credentials, err := resolveScanCredentials(&req)
+	if err != nil {
+		logger.Printf("Failed to resolve scan credentials: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Create scan ID
 	scanID := fmt.Sprintf("scan_%d", time.Now().UnixNano())
 
+	// The scan outlives this HTTP request, so it doesn't inherit r.Context()
+	ctx, cancel := context.WithCancel(context.Background())
+
 	// Create active scan
 	scan := &ActiveScan{
-		ID:           scanID,
-		TotalIPs:     len(req.IPs),
-		ScannedCount: 0,
-		CamerasFound: 0,
-		ProgressChan: make(chan ScanProgress, 100),
-		Clients:      make(map[*websocket.Conn]bool),
-		StartTime:    time.Now(),
+		ID:        scanID,
+		TotalIPs:  len(ips),
+		StartTime: time.Now(),
+		clients:   make(map[*scanClient]bool),
+		state:     scanStateScanning,
+		cancel:    cancel,
 	}
 
 	activeScansMu.Lock()
 	activeScans[scanID] = scan
 	activeScansMu.Unlock()
 
-	logger.Printf("Starting network scan %s: %d IPs", scanID, len(req.IPs))
+	logger.Printf("Starting network scan %s: %d IPs (%d excluded), endpoints %v, %d-%d workers, %d credential sets",
+		scanID, len(ips), excluded, endpoints, adaptive.MinWorkers, adaptive.MaxWorkers, len(credentials))
 
 	// Start scan in background with worker pool
-	go runNetworkScan(scan, req.IPs, req.Username, req.Password)
+	go runNetworkScan(ctx, scan, ips, &scanOptions{
+		endpoints:    endpoints,
+		adaptive:     adaptive,
+		probeTimeout: probeTimeout,
+		deviceTypes:  deviceTypes,
+		credentials:  credentials,
+	})
 
 	// Return 202 Accepted immediately
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"scan_id":   scanID,
-		"total_ips": len(req.IPs),
-		"status":    "scanning",
+		"scan_id":      scanID,
+		"total_ips":    len(ips),
+		"excluded_ips": excluded,
+		"status":       scanStateScanning,
 	})
 }
 
-// runNetworkScan executes the scan with a worker pool
-func runNetworkScan(scan *ActiveScan, ips []string, username, password string) {
+// runNetworkScan sweeps ips for open endpoint ports, then identifies the open
+// hosts in batches sized by the adaptive controller
+// Workers stop picking up IPs and abort in-flight requests once ctx is done
+func runNetworkScan(ctx context.Context, scan *ActiveScan, ips []string, opts *scanOptions) {
 	defer func() {
-		// Send completion message
-		scan.ProgressChan <- ScanProgress{
-			ScanID:       scan.ID,
-			ScannedCount: scan.TotalIPs,
-			TotalIPs:     scan.TotalIPs,
-			CamerasFound: scan.CamerasFound,
-			PercentDone:  100.0,
-			IsComplete:   true,
+		state := scanStateComplete
+		if ctx.Err() != nil {
+			state = scanStateCancelled
 		}
+		scan.stateMu.Lock()
+		scan.state = state
+		scan.stateMu.Unlock()
+		scan.cancel()
 
-		// Close progress channel after a delay (let clients receive completion)
-		time.Sleep(2 * time.Second)
-		close(scan.ProgressChan)
+		// Send completion message (partial counts if cancelled)
+		final := scan.finish(state)
 
 		// Clean up scan after 1 minute
 		time.AfterFunc(1*time.Minute, func() {
@@ -139,140 +461,496 @@ func runNetworkScan(scan *ActiveScan, ips []string, username, password string) {
 		})
 
 		duration := time.Since(scan.StartTime)
-		logger.Printf("Scan %s complete: %d cameras found in %v", scan.ID, scan.CamerasFound, duration)
+		logger.Printf("Scan %s %s: %d/%d IPs scanned, %d cameras and %d other hosts found (%d excluded) in %v",
+			scan.ID, state, final.ScannedCount, final.TotalIPs, final.CamerasFound, final.AliveHosts, final.Excluded, duration)
 	}()
 
-	// Worker pool: 50 concurrent camera checks
-	const maxWorkers = 50
-	ipChan := make(chan string, len(ips))
-	var wg sync.WaitGroup
-
-	// Start workers
-	for i := 0; i < maxWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for ip := range ipChan {
-				checkAndReportCamera(scan, ip, username, password)
+	hosts := runTCPSweep(ctx, scan, ips, opts)
+
+	controller := newAdaptiveController(opts.adaptive)
+
+	for start := 0; start < len(hosts); {
+		if !scan.waitWhilePaused(ctx) {
+			return
+		}
+
+		batch := hosts[start:min(start+controller.BatchSize(), len(hosts))]
+		start += len(batch)
+
+		// Check the whole batch concurrently
+		results := make([]scanResult, len(batch))
+		var wg sync.WaitGroup
+		for i, host := range batch {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !scan.waitWhilePaused(ctx) {
+					return
+				}
+				results[i] = checkAndReportCamera(ctx, scan, host, opts)
+			}()
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return
+		}
+		controller.Adjust(scan.ID, newBatchMetrics(results))
+
+		if start < len(hosts) {
+			select {
+			case <-time.After(controller.InterBatchDelay()):
+			case <-ctx.Done():
+				return
 			}
-		}()
+		}
 	}
+}
 
-	// Feed IPs to workers
-	for _, ip := range ips {
-		ipChan <- ip
-	}
-	close(ipChan)
+// currentState returns the scan's state
+func (scan *ActiveScan) currentState() string {
+	scan.stateMu.Lock()
+	defer scan.stateMu.Unlock()
+	return scan.state
+}
+
+// waitWhilePaused blocks while the scan is paused
+// Returns false once ctx is done and the worker should stop
+func (scan *ActiveScan) waitWhilePaused(ctx context.Context) bool {
+	for {
+		scan.stateMu.Lock()
+		resume := scan.resume
+		scan.stateMu.Unlock()
+
+		if resume == nil {
+			return ctx.Err() == nil
+		}
 
-	// Wait for all workers to finish
-	wg.Wait()
+		select {
+		case <-resume:
+		case <-ctx.Done():
+			return false
+		}
+	}
 }
 
-// checkAndReportCamera checks a single IP and reports progress
-func checkAndReportCamera(scan *ActiveScan, ip, username, password string) {
-	// Build request URL
-	url := fmt.Sprintf("https://%s/axis-cgi/basicdeviceinfo.cgi", ip)
+// setState applies a control action and returns the new state
+func (scan *ActiveScan) setState(action string) (string, error) {
+	scan.stateMu.Lock()
+	defer scan.stateMu.Unlock()
 
-	// Build request body
-	body := map[string]interface{}{
-		"apiVersion": "1.0",
-		"method":     "getProperties",
-		"params": map[string]interface{}{
-			"propertyList": []string{
-				"Brand",
-				"ProdType",
-				"ProdNbr",
-				"ProdFullName",
-				"SerialNumber",
-			},
-		},
+	if scan.state == scanStateCancelled || scan.state == scanStateComplete {
+		return scan.state, fmt.Errorf("scan already %s", scan.state)
 	}
 
-	req := &ProxyRequest{
-		URL:      url,
-		Method:   "POST",
-		Username: username,
-		Password: password,
-		Body:     body,
+	switch action {
+	case "pause":
+		if scan.state == scanStatePaused {
+			return scan.state, fmt.Errorf("scan already paused")
+		}
+		scan.state = scanStatePaused
+		scan.resume = make(chan struct{})
+	case "resume":
+		if scan.state != scanStatePaused {
+			return scan.state, fmt.Errorf("scan is not paused")
+		}
+		scan.state = scanStateScanning
+		close(scan.resume)
+		scan.resume = nil
+	case "cancel":
+		// Paused workers wake up on ctx.Done and exit
+		scan.state = scanStateCancelled
+		scan.cancel()
 	}
+	return scan.state, nil
+}
 
-	// Make camera request
-	resp, err := makeCameraRequest(req)
+// handleScanControl returns a handler that pauses, resumes or cancels a scan by scan_id
+func handleScanControl(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setCORSHeaders(w, r) {
+			return
+		}
 
-	// Update scan progress
-	scan.ScannedCount++
-	scannedCount := scan.ScannedCount
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		scanID := r.URL.Query().Get("scan_id")
+		if scanID == "" {
+			http.Error(w, "scan_id required", http.StatusBadRequest)
+			return
+		}
 
-	progress := ScanProgress{
-		ScanID:       scan.ID,
-		IP:           ip,
-		ScannedCount: scannedCount,
-		TotalIPs:     scan.TotalIPs,
-		CamerasFound: scan.CamerasFound,
-		PercentDone:  float64(scannedCount) / float64(scan.TotalIPs) * 100.0,
-		IsComplete:   false,
+		activeScansMu.RLock()
+		scan, exists := activeScans[scanID]
+		activeScansMu.RUnlock()
+
+		if !exists {
+			http.Error(w, "Scan not found", http.StatusNotFound)
+			return
+		}
+
+		state, err := scan.setState(action)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		// The final message reports cancellation; pause/resume are reported here
+		progress := scan.Snapshot()
+		if action != "cancel" {
+			progress = scan.announce(state)
+		}
+
+		logger.Printf("Scan %s: %s requested (%d/%d IPs scanned)", scanID, action, progress.ScannedCount, scan.TotalIPs)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"scan_id":       scanID,
+			"status":        state,
+			"scanned_count": progress.ScannedCount,
+			"total_ips":     scan.TotalIPs,
+			"cameras_found": progress.CamerasFound,
+		})
+	}
+}
+
+// checkAndReportCamera identifies a host that passed the TCP sweep and reports progress
+// Open endpoints are tried in order until one answers basicdeviceinfo with 200;
+// on each endpoint the credential sets are tried in order while the device rejects them
+// Axis devices of every class in opts.deviceTypes are reported, not only cameras
+func checkAndReportCamera(ctx context.Context, scan *ActiveScan, host sweepHost, opts *scanOptions) scanResult {
+	start := time.Now()
+	ip := host.IP
+
+	var resp ProxyResponse
+	var err error
+	var endpoint ScanEndpoint
+	var credential *scanCredential
+	answered := false
+	credentials := credentialsFor(opts.credentials, ip)
+	for _, candidate := range host.openEndpoints(opts.endpoints) {
+		candidateResp, candidateCred, candidateErr := fetchDeviceInfo(ctx, candidate.BaseURL(ip), credentials)
+		success := candidateErr == nil && candidateResp.Status == 200
+
+		// Keep the first HTTP answer so a 401 isn't masked by a later endpoint's error
+		if success || endpoint.Port == 0 || (!answered && candidateErr == nil) {
+			resp, err, endpoint, credential = candidateResp, candidateErr, candidate, candidateCred
+			answered = candidateErr == nil
+		}
+		if success || ctx.Err() != nil {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		// Cancelled mid-check: the host wasn't scanned, so don't count or report it
+		return scanResult{}
 	}
 
+	result := scanResult{outcome: scanOutcomeResponded, duration: time.Since(start)}
 	if err != nil {
-		// Not a camera or error - don't report
-		logger.Printf("[Scan %s] %s: not a camera (%v)", scan.ID, ip, err)
-	} else if resp.Status == 200 {
+		result.outcome = classifyScanError(err)
+	}
+
+	var device, nonCamera map[string]interface{}
+	var status, reason string
+	switch {
+	case err != nil && isTLSHandshakeError(err):
+		status, reason = scanStatusTLSError, fmt.Sprintf("TLS handshake failed: %v", err)
+	case isAuthFailure(resp, err):
+		status, reason = scanStatusAuthFailed, fmt.Sprintf("all %d credential sets rejected", len(credentials))
+		if credentials[0].index < 0 {
+			reason = "no credential set is allowed for this host"
+		}
+	case err != nil:
+		status, reason = scanStatusNoResponse, fmt.Sprintf("no device info: %v", err)
+	case resp.Status != 200:
+		status, reason = scanStatusNotAxis, fmt.Sprintf("device info returned HTTP %d", resp.Status)
+	default:
 		// Parse camera data
-		data := resp.Data["data"].(map[string]interface{})
-		propertyList := data["propertyList"].(map[string]interface{})
+		data, _ := resp.Data["data"].(map[string]interface{})
+		propertyList, _ := data["propertyList"].(map[string]interface{})
 
 		// Check if it's an Axis device
 		brand, _ := propertyList["Brand"].(string)
-		if brand == "AXIS" {
-			// Get device type from product number
-			prodNbr, _ := propertyList["ProdNbr"].(string)
-			deviceType := getDeviceType(prodNbr)
-
-			if deviceType == "camera" {
-				scan.CamerasFound++
-
-				progress.CamerasFound = scan.CamerasFound
-				progress.Camera = map[string]interface{}{
-					"ip":           ip,
-					"manufacturer": brand,
-					"model":        propertyList["ProdFullName"],
-					"serialNumber": propertyList["SerialNumber"],
-					"productNumber": prodNbr,
-					"deviceType":   deviceType,
-				}
+		if brand != "AXIS" {
+			status, reason = scanStatusNotAxis, "not an Axis device"
+			break
+		}
+
+		// Get device type from product number
+		prodNbr, _ := propertyList["ProdNbr"].(string)
+		deviceType := getDeviceType(prodNbr)
 
-				logger.Printf("[Scan %s] ✅ Found camera at %s: %s", scan.ID, ip, propertyList["ProdFullName"])
+		if opts.deviceTypes != nil && !opts.deviceTypes[deviceType] {
+			status, reason = scanStatusExcluded, fmt.Sprintf("Axis %s excluded by device type filter", deviceType)
+			break
+		}
+
+		status = scanStatusDeviceFound
+		if deviceType == deviceTypeCamera {
+			status = scanStatusCameraFound
+		}
+		device = map[string]interface{}{
+			"ip":            ip,
+			"manufacturer":  brand,
+			"model":         propertyList["ProdFullName"],
+			"serialNumber":  propertyList["SerialNumber"],
+			"productNumber": prodNbr,
+			"deviceType":    deviceType,
+			"endpoint":      endpoint.String(),
+			"url":           endpoint.BaseURL(ip),
+			"status":        status,
+		}
+		if credential != nil {
+			device["credential"] = credential.report()
+		}
+		if resp.Certificate != nil {
+			device["certificate"] = resp.Certificate
+			if resp.Certificate.ExpiryStatus != certExpiryOK {
+				logger.Printf("[Scan %s] ⚠️ Certificate for %s is %s (notAfter: %s)",
+					scan.ID, ip, resp.Certificate.ExpiryStatus, resp.Certificate.NotAfter.Format(time.RFC3339))
 			}
 		}
+
+		credentialIndex := -1
+		if credential != nil {
+			credentialIndex = credential.index
+		}
+		logger.Printf("[Scan %s] ✅ Found Axis %s at %s (%s, credential set %d): %s",
+			scan.ID, deviceType, ip, endpoint, credentialIndex, propertyList["ProdFullName"])
 	}
 
-	// Send progress update
-	select {
-	case scan.ProgressChan <- progress:
-	default:
-		// Channel full, skip this update
+	if status == scanStatusExcluded {
+		// Counted separately, never listed as a non-camera
+		logger.Printf("[Scan %s] %s: not reported (%s)", scan.ID, ip, reason)
+	} else if device == nil {
+		// Alive (it had open ports) but not a reported device
+		nonCamera = map[string]interface{}{
+			"ip":        ip,
+			"openPorts": host.OpenPorts,
+			"status":    status,
+			"reason":    reason,
+		}
+		if endpoint.Port != 0 {
+			nonCamera["endpoint"] = endpoint.String()
+		}
+		if status == scanStatusAuthFailed {
+			logger.Printf("[Scan %s] 🚫 %s: %s - check the scan credentials", scan.ID, ip, reason)
+		} else {
+			logger.Printf("[Scan %s] %s: not reported (%s: %s)", scan.ID, ip, status, reason)
+		}
+	}
+
+	// Update scan progress
+	scan.record(ip, status, device, nonCamera)
+
+	return result
+}
+
+// fetchDeviceInfo queries basicdeviceinfo.cgi on the device at baseURL
+// The device is probed once without credentials; each credential set is then tried
+// only with the scheme the device challenged, so a wrong set costs one failed login
+// rather than two (Basic and Digest) and scans don't lock out camera accounts
+// Returns the set the device answered, or nil if none was needed or none was accepted
+func fetchDeviceInfo(ctx context.Context, baseURL string, credentials []scanCredential) (ProxyResponse, *scanCredential, error) {
+	resp, err := tryUnauthenticatedRequest(ctx, deviceInfoRequest(baseURL, &scanCredential{}))
+	if err != nil {
+		if isTimeoutError(err) || isConnectionRefusedError(err) {
+			return ProxyResponse{}, nil, fmt.Errorf("device not responding: %w", err)
+		}
+		return ProxyResponse{}, nil, err
+	}
+	if resp.Status != 401 {
+		return resp, nil, nil
+	}
+
+	scheme := challengedScheme(resp.challenges)
+	if scheme == "" {
+		return resp, nil, fmt.Errorf("%w: device offered neither Basic nor Digest", errNoDigestChallenge)
+	}
+
+	for i := range credentials {
+		cred := &credentials[i]
+		if cred.index < 0 {
+			continue // No set is allowed for this host: probe only
+		}
+
+		var attemptResp ProxyResponse
+		var attemptErr error
+		switch {
+		case scheme == authSchemeDigest:
+			attemptResp, attemptErr = tryDigestAuth(ctx, deviceInfoRequest(baseURL, cred))
+		case cred.credentialID != "" && strings.HasPrefix(baseURL, "http://"):
+			// SECURITY: Never send vault credentials in the clear
+			logger.Printf("Vault credential %s not sent as Basic Auth over plain HTTP to %s", cred.credentialID, baseURL)
+			continue
+		default:
+			attemptResp, attemptErr = tryBasicAuth(ctx, deviceInfoRequest(baseURL, cred))
+		}
+		if ctx.Err() != nil {
+			return ProxyResponse{}, nil, ctx.Err()
+		}
+		if !isAuthFailure(attemptResp, attemptErr) {
+			// Accepted, or an answer another credential set won't change
+			return attemptResp, cred, attemptErr
+		}
+		resp, err = attemptResp, attemptErr
+	}
+	return resp, nil, err
+}
+
+const (
+	authSchemeBasic  = "basic"
+	authSchemeDigest = "digest"
+)
+
+// challengedScheme returns the scheme to answer a 401 with: Digest when offered
+// (the password never crosses the wire), otherwise Basic, or "" for neither
+func challengedScheme(challenges []string) string {
+	scheme := ""
+	for _, challenge := range challenges {
+		name, _, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+		switch strings.ToLower(name) {
+		case authSchemeDigest:
+			return authSchemeDigest
+		case authSchemeBasic:
+			scheme = authSchemeBasic
+		}
+	}
+	return scheme
+}
+
+// deviceInfoRequest builds the basicdeviceinfo.cgi request for the device at baseURL
+func deviceInfoRequest(baseURL string, cred *scanCredential) *ProxyRequest {
+	body := map[string]interface{}{
+		"apiVersion": "1.0",
+		"method":     "getProperties",
+		"params": map[string]interface{}{
+			"propertyList": []string{
+				"Brand",
+				"ProdType",
+				"ProdNbr",
+				"ProdFullName",
+				"SerialNumber",
+			},
+		},
+	}
+
+	return &ProxyRequest{
+		URL:          baseURL + "/axis-cgi/basicdeviceinfo.cgi",
+		Method:       "POST",
+		Username:     cred.username,
+		Password:     cred.password,
+		CredentialID: cred.credentialID,
+		Body:         body,
 	}
 }
 
 // getDeviceType determines device type from product number
 func getDeviceType(prodNbr string) string {
 	if len(prodNbr) == 0 {
-		return "unknown"
+		return deviceTypeUnknown
 	}
 
+	prodNbr = strings.ToUpper(prodNbr)
 	prefix := string(prodNbr[0])
 	switch prefix {
 	case "M", "P", "Q":
-		return "camera"
+		// The 7 series (M7104, P7304, Q7424...) are video encoders
+		if len(prodNbr) > 1 && prodNbr[1] == '7' {
+			return deviceTypeEncoder
+		}
+		return deviceTypeCamera
+	case "F", "V":
+		return deviceTypeEncoder
 	case "C":
-		return "speaker"
+		return deviceTypeSpeaker
 	case "I":
-		return "intercom"
+		return deviceTypeIntercom
 	case "A":
-		return "access-control"
+		return deviceTypeAccessControl
 	default:
-		return "unknown"
+		return deviceTypeUnknown
+	}
+}
+
+// isTLSHandshakeError checks if err came from the TLS handshake or certificate checks
+func isTLSHandshakeError(err error) bool {
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &recordErr) || errors.As(err, &verifyErr) || errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &invalidErr) || errors.As(err, &hostnameErr) {
+		return true
+	}
+
+	// Alerts sent by the device surface as net.OpError{Op: "remote error"}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "remote error" {
+		return true
+	}
+
+	// net/http replaces the RecordHeaderError when a plain HTTP server answers
+	return errors.Is(err, http.ErrSchemeMismatch)
+}
+
+// isAuthFailure checks if a device info attempt was rejected for its credentials
+func isAuthFailure(resp ProxyResponse, err error) bool {
+	if err != nil {
+		return isAuthChallengeError(err)
+	}
+	return resp.Status == 401 || resp.Status == 403
+}
+
+// isAuthChallengeError checks if err means the device demanded authentication
+// makeCameraRequest could not complete (e.g. Basic rejected, no Digest challenge)
+func isAuthChallengeError(err error) bool {
+	return errors.Is(err, errNoDigestChallenge)
+}
+
+// handleScanStatus returns a consistent snapshot of a scan's progress by scan_id
+func handleScanStatus(w http.ResponseWriter, r *http.Request) {
+	if !setCORSHeaders(w, r) {
+		return
+	}
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
 	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	scanID := r.URL.Query().Get("scan_id")
+	if scanID == "" {
+		http.Error(w, "scan_id required", http.StatusBadRequest)
+		return
+	}
+
+	activeScansMu.RLock()
+	scan, exists := activeScans[scanID]
+	activeScansMu.RUnlock()
+
+	if !exists {
+		http.Error(w, "Scan not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scan.Snapshot())
 }
 
 // handleScanResults handles WebSocket connections for scan progress
@@ -300,31 +978,39 @@ func handleScanResults(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	// Register client
-	scan.ClientsMu.Lock()
-	scan.Clients[conn] = true
-	scan.ClientsMu.Unlock()
+	client, snapshot := scan.subscribe(conn)
+	if err := conn.WriteJSON(snapshot); err != nil {
+		logger.Printf("Error sending snapshot: %v", err)
+		if client != nil {
+			scan.unsubscribe(client)
+		}
+		return
+	}
+	if client == nil {
+		// Scan already finished - the snapshot was the final state
+		return
+	}
 
 	logger.Printf("Client connected to scan %s", scanID)
 
-	// Unregister on disconnect
-	defer func() {
-		scan.ClientsMu.Lock()
-		delete(scan.Clients, conn)
-		scan.ClientsMu.Unlock()
-		logger.Printf("Client disconnected from scan %s", scanID)
+	// Detect disconnects; closing client.send ends the stream below
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				scan.unsubscribe(client)
+				return
+			}
+		}
 	}()
 
-	// Stream progress updates
-	for progress := range scan.ProgressChan {
+	// Stream progress updates until the client is dropped or the scan completes
+	for progress := range client.send {
 		if err := conn.WriteJSON(progress); err != nil {
 			logger.Printf("Error sending progress: %v", err)
-			break
-		}
-
-		// Close connection after completion message
-		if progress.IsComplete {
+			scan.unsubscribe(client)
 			break
 		}
 	}
+
+	logger.Printf("Client disconnected from scan %s", scanID)
 }
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestScanErrorsWithoutDigestChallenge(t *testing.T) {
+	// Rejects every request without offering a Digest challenge
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	resp, err := makeCameraRequest(context.Background(), &ProxyRequest{
+		URL:      server.URL + "/axis-cgi/basicdeviceinfo.cgi",
+		Method:   "POST",
+		Username: "root",
+		Password: "wrong",
+	})
+	if !errors.Is(err, errNoDigestChallenge) {
+		t.Fatalf("error = %v, want errNoDigestChallenge", err)
+	}
+	if !isAuthFailure(resp, err) {
+		t.Errorf("isAuthFailure(%v) = false", err)
+	}
+	if isTLSHandshakeError(err) {
+		t.Errorf("isTLSHandshakeError(%v) = true", err)
+	}
+	if got := classifyScanError(err); got != scanOutcomeResponded {
+		t.Errorf("classifyScanError(%v) = %v, want scanOutcomeResponded", err, got)
+	}
+}
+
+func TestScanErrorsHTTPSToHTTPServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, err := makeCameraRequest(context.Background(), &ProxyRequest{
+		URL:    strings.Replace(server.URL, "http://", "https://", 1),
+		Method: "GET",
+	})
+	if err == nil {
+		t.Fatal("expected an error for HTTPS to a plain HTTP server")
+	}
+	if !isTLSHandshakeError(err) {
+		t.Errorf("isTLSHandshakeError(%v) = false", err)
+	}
+	if isAuthFailure(ProxyResponse{}, err) {
+		t.Errorf("isAuthFailure(%v) = true", err)
+	}
+}
+
+func TestClassifyScanError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want scanOutcome
+	}{
+		{"dial failure", &net.OpError{Op: "dial", Err: errors.New("no route to host")}, scanOutcomeNoResponse},
+		{"missing challenge", errNoDigestChallenge, scanOutcomeResponded},
+		{"wrapped missing challenge", errors.Join(errors.New("request failed"), errNoDigestChallenge), scanOutcomeResponded},
+		{"timeout", context.DeadlineExceeded, scanOutcomeTimeout},
+		{"reset", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, scanOutcomeError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyScanError(tt.err); got != tt.want {
+				t.Errorf("classifyScanError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanNetworkChecksOriginBeforeVault(t *testing.T) {
+	summary, err := credentialVault.Add("lobby", "root", "s3cret", []string{"192.168.1.0/24"})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	t.Cleanup(func() { credentialVault.Delete(summary.ID) })
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "existing credential", body: `{"ips":["192.168.1.10"],"credential_id":"` + summary.ID + `"}`},
+		{name: "unknown credential", body: `{"ips":["192.168.1.10"],"credential_id":"cred_0000000000000000"}`},
+		{name: "unknown credential set", body: `{"ips":["192.168.1.10"],"credentials":[{"username":"root"},{"credential_id":"cred_0000000000000000"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/scan-network", strings.NewReader(tt.body))
+			req.Header.Set("Origin", "chrome-extension://untrustedextensionid")
+			rec := httptest.NewRecorder()
+			handleScanNetwork(rec, req)
+
+			// Both must look the same, or the response reveals which IDs exist
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("status = %d (%s), want %d", rec.Code, strings.TrimSpace(rec.Body.String()), http.StatusForbidden)
+			}
+		})
+	}
+}
+
+// fakeAxisCamera answers basicdeviceinfo.cgi for admin/right, challenging with scheme
+// It counts the logins it rejected per username and the schemes clients tried
+type fakeAxisCamera struct {
+	scheme string // "basic" or "digest"
+
+	mu       sync.Mutex
+	rejected map[string]int
+	schemes  map[string]int
+}
+
+func (c *fakeAxisCamera) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	auth := r.Header.Get("Authorization")
+	if auth != "" {
+		scheme, _, _ := strings.Cut(auth, " ")
+		c.schemes[strings.ToLower(scheme)]++
+
+		username := ""
+		accepted := false
+		if user, pass, ok := r.BasicAuth(); ok && c.scheme == authSchemeBasic {
+			username, accepted = user, user == "admin" && pass == "right"
+		} else if strings.HasPrefix(auth, "Digest ") && c.scheme == authSchemeDigest {
+			// Enough for the scan: the response hash itself is covered by the digest tests
+			_, rest, _ := strings.Cut(auth, `username="`)
+			username, _, _ = strings.Cut(rest, `"`)
+			accepted = username == "admin"
+		}
+		if accepted {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"data":{"propertyList":{"Brand":"AXIS","ProdNbr":"M3106","ProdFullName":"AXIS M3106","SerialNumber":"ACCC8E000001"}}}`))
+			return
+		}
+		c.rejected[username]++
+	}
+
+	if c.scheme == authSchemeDigest {
+		w.Header().Set("WWW-Authenticate", `Digest realm="AXIS_ACCC8E000001", nonce="b2jA1k5xBQA=", algorithm=MD5, qop="auth"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Basic realm="AXIS_ACCC8E000001"`)
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+func TestCheckCameraCredentialSets(t *testing.T) {
+	wrong := []scanCredential{
+		{index: 0, username: "root", password: "wrong"},
+		{index: 1, username: "operator", password: "wrong"},
+	}
+	right := scanCredential{index: 2, username: "admin", password: "right"}
+	vault := scanCredential{index: 0, credentialID: "cred_0000000000000001", username: "viewer", password: "wrong"}
+
+	tests := []struct {
+		name           string
+		scheme         string
+		credentials    []scanCredential
+		wantStatus     string
+		wantCredential int // Index of the reported credential set, -1 for none
+		wantRejected   map[string]int
+	}{
+		{
+			name:           "digest: third set works",
+			scheme:         authSchemeDigest,
+			credentials:    append(append([]scanCredential{}, wrong...), right),
+			wantStatus:     scanStatusCameraFound,
+			wantCredential: 2,
+			wantRejected:   map[string]int{"root": 1, "operator": 1},
+		},
+		{
+			name:           "basic: third set works",
+			scheme:         authSchemeBasic,
+			credentials:    append(append([]scanCredential{}, wrong...), right),
+			wantStatus:     scanStatusCameraFound,
+			wantCredential: 2,
+			wantRejected:   map[string]int{"root": 1, "operator": 1},
+		},
+		{
+			name:           "digest: every set rejected once",
+			scheme:         authSchemeDigest,
+			credentials:    wrong,
+			wantStatus:     scanStatusAuthFailed,
+			wantCredential: -1,
+			wantRejected:   map[string]int{"root": 1, "operator": 1},
+		},
+		{
+			name:           "basic over HTTP: vault set never sent",
+			scheme:         authSchemeBasic,
+			credentials:    []scanCredential{vault, {index: 1, username: "admin", password: "right"}},
+			wantStatus:     scanStatusCameraFound,
+			wantCredential: 1,
+			wantRejected:   map[string]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			camera := &fakeAxisCamera{scheme: tt.scheme, rejected: map[string]int{}, schemes: map[string]int{}}
+			server := httptest.NewServer(camera)
+			defer server.Close()
+
+			_, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+			port, _ := strconv.Atoi(portStr)
+			scan := newTestScan(1)
+			opts := &scanOptions{
+				endpoints:   []ScanEndpoint{{Scheme: "http", Port: port}},
+				credentials: tt.credentials,
+			}
+			checkAndReportCamera(context.Background(), scan, sweepHost{IP: "127.0.0.1", OpenPorts: []int{port}}, opts)
+
+			camera.mu.Lock()
+			defer camera.mu.Unlock()
+			if !reflect.DeepEqual(camera.rejected, tt.wantRejected) {
+				t.Errorf("rejected logins = %v, want %v", camera.rejected, tt.wantRejected)
+			}
+			for scheme := range camera.schemes {
+				if scheme != tt.scheme {
+					t.Errorf("tried %s against a %s challenge", scheme, tt.scheme)
+				}
+			}
+
+			scan.mu.Lock()
+			defer scan.mu.Unlock()
+			if tt.wantStatus == scanStatusAuthFailed {
+				if len(scan.nonCameras) != 1 || scan.nonCameras[0]["status"] != scanStatusAuthFailed {
+					t.Fatalf("non-cameras = %v, want one %s", scan.nonCameras, scanStatusAuthFailed)
+				}
+				return
+			}
+			if len(scan.devices) != 1 {
+				t.Fatalf("devices = %v, non-cameras = %v, want one device", scan.devices, scan.nonCameras)
+			}
+			device := scan.devices[0]
+			if device["status"] != tt.wantStatus {
+				t.Errorf("status = %v, want %s", device["status"], tt.wantStatus)
+			}
+			credential, _ := device["credential"].(map[string]interface{})
+			if credential == nil || credential["index"] != tt.wantCredential {
+				t.Fatalf("credential = %v, want set %d", device["credential"], tt.wantCredential)
+			}
+			if credential["username"] != sanitizeCredential("admin") {
+				t.Errorf("credential username = %v, want it masked", credential["username"])
+			}
+		})
+	}
+}
+
+func TestChallengedScheme(t *testing.T) {
+	tests := []struct {
+		name       string
+		challenges []string
+		want       string
+	}{
+		{"digest", []string{`Digest realm="AXIS", nonce="abc"`}, authSchemeDigest},
+		{"basic", []string{`Basic realm="AXIS"`}, authSchemeBasic},
+		{"digest preferred", []string{`Basic realm="AXIS"`, `Digest realm="AXIS", nonce="abc"`}, authSchemeDigest},
+		{"case insensitive", []string{`DIGEST realm="AXIS"`}, authSchemeDigest},
+		{"unsupported", []string{`Bearer realm="AXIS"`}, ""},
+		{"none", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := challengedScheme(tt.challenges); got != tt.want {
+				t.Errorf("challengedScheme(%q) = %q, want %q", tt.challenges, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSweepTimeout is the TCP connect timeout of the pre-probe sweep
+	defaultSweepTimeout = 500 * time.Millisecond
+	// maxSweepTimeout caps probe_timeout_ms
+	maxSweepTimeout = 10 * time.Second
+	// sweepBatchFactor scales the adaptive batch size for connect probes, which are
+	// much cheaper than identifying a device over HTTP
+	sweepBatchFactor = 3
+	// maxSweepBatch caps the number of hosts probed concurrently (each dials every endpoint port)
+	maxSweepBatch = 256
+)
+
+// sweepHost is a host that accepted a TCP connection on at least one endpoint port
+type sweepHost struct {
+	IP        string
+	OpenPorts []int
+}
+
+// sweepTimeout returns the connect timeout for a ScanRequest
+func sweepTimeout(req *ScanRequest) (time.Duration, error) {
+	if req.ProbeTimeoutMs == 0 {
+		return defaultSweepTimeout, nil
+	}
+	timeout := time.Duration(req.ProbeTimeoutMs) * time.Millisecond
+	if timeout < 0 || timeout > maxSweepTimeout {
+		return 0, fmt.Errorf("probe_timeout_ms must be between 1 and %d", maxSweepTimeout.Milliseconds())
+	}
+	return timeout, nil
+}
+
+// runTCPSweep connects to every endpoint port on every IP and returns the hosts with open ports
+// IPs are probed in batches sized and spaced by an adaptive controller built from
+// opts.adaptive, so intensity, max_workers and inter_batch_delay_ms apply to the sweep too
+// Hosts that don't answer are counted as scanned (no_response); hosts that answer
+// only with refusals are reported as alive non-cameras (closed)
+func runTCPSweep(ctx context.Context, scan *ActiveScan, ips []string, opts *scanOptions) []sweepHost {
+	ports := endpointPorts(opts.endpoints)
+	controller := newAdaptiveController(opts.adaptive)
+
+	var mu sync.Mutex
+	var hosts []sweepHost
+
+	for start := 0; start < len(ips); {
+		if !scan.waitWhilePaused(ctx) {
+			return nil
+		}
+
+		batch := ips[start:min(start+sweepBatchSize(controller), len(ips))]
+		start += len(batch)
+
+		// Probe the whole batch concurrently
+		results := make([]scanResult, len(batch))
+		var wg sync.WaitGroup
+		for i, ip := range batch {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !scan.waitWhilePaused(ctx) {
+					return
+				}
+
+				probeStart := time.Now()
+				openPorts, alive := probeOpenPorts(ctx, ip, ports, opts.probeTimeout)
+				if ctx.Err() != nil {
+					return
+				}
+				if len(openPorts) > 0 {
+					results[i] = scanResult{outcome: scanOutcomeResponded, duration: time.Since(probeStart)}
+					mu.Lock()
+					hosts = append(hosts, sweepHost{IP: ip, OpenPorts: openPorts})
+					mu.Unlock()
+					return
+				}
+				results[i] = scanResult{outcome: scanOutcomeNoResponse}
+
+				// Nothing to identify - this IP is done
+				if !alive {
+					scan.record(ip, scanStatusNoResponse, nil, nil)
+					return
+				}
+				scan.record(ip, scanStatusClosed, nil, map[string]interface{}{
+					"ip":     ip,
+					"status": scanStatusClosed,
+					"reason": "connection refused on every camera port",
+				})
+			}()
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return nil
+		}
+		controller.Adjust(scan.ID, newBatchMetrics(results))
+
+		if start < len(ips) {
+			select {
+			case <-time.After(controller.InterBatchDelay()):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+
+	// Identify hosts in address order regardless of which probe finished first
+	order := make(map[string]int, len(ips))
+	for i, ip := range ips {
+		order[ip] = i
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return order[hosts[i].IP] < order[hosts[j].IP]
+	})
+
+	logger.Printf("[Scan %s] TCP sweep: %d of %d hosts have open ports %v", scan.ID, len(hosts), len(ips), ports)
+	return hosts
+}
+
+// sweepBatchSize returns the number of IPs to probe concurrently in the next sweep batch
+func sweepBatchSize(controller *adaptiveController) int {
+	return min(maxSweepBatch, controller.BatchSize()*sweepBatchFactor)
+}
+
+// dialSweepPort opens the sweep's TCP connections (replaced in tests)
+var dialSweepPort = func(ctx context.Context, address string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+// probeOpenPorts dials every port on ip concurrently
+// Returns the open ports, and whether the host answered at all (a refusal counts)
+func probeOpenPorts(ctx context.Context, ip string, ports []int, timeout time.Duration) ([]int, bool) {
+	var mu sync.Mutex
+	var open []int
+	alive := false
+	var wg sync.WaitGroup
+
+	for _, port := range ports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := dialSweepPort(ctx, net.JoinHostPort(ip, strconv.Itoa(port)), timeout)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				conn.Close()
+				open = append(open, port)
+				alive = true
+			} else if isConnectionRefusedError(err) {
+				alive = true
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Ints(open)
+	return open, alive
+}
+
+// endpointPorts returns the distinct ports of endpoints
+func endpointPorts(endpoints []ScanEndpoint) []int {
+	seen := make(map[int]bool)
+	var ports []int
+	for _, endpoint := range endpoints {
+		if !seen[endpoint.Port] {
+			seen[endpoint.Port] = true
+			ports = append(ports, endpoint.Port)
+		}
+	}
+	return ports
+}
+
+// openEndpoints returns the endpoints whose port the sweep found open, keeping their order
+func (h sweepHost) openEndpoints(endpoints []ScanEndpoint) []ScanEndpoint {
+	var open []ScanEndpoint
+	for _, endpoint := range endpoints {
+		for _, port := range h.OpenPorts {
+			if endpoint.Port == port {
+				open = append(open, endpoint)
+				break
+			}
+		}
+	}
+	return open
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRunTCPSweep(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	openPort := listener.Addr().(*net.TCPAddr).Port
+
+	// A port that was just free: connecting to it is refused
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	// Some networks reject TEST-NET addresses outright, so make the silent host deterministic
+	previous := dialSweepPort
+	dialSweepPort = func(ctx context.Context, address string, timeout time.Duration) (net.Conn, error) {
+		if host, _, _ := net.SplitHostPort(address); host == "192.0.2.1" {
+			return nil, fmt.Errorf("dial tcp %s: i/o timeout", address)
+		}
+		return previous(ctx, address, timeout)
+	}
+	t.Cleanup(func() { dialSweepPort = previous })
+
+	tests := []struct {
+		name          string
+		ip            string
+		ports         []int
+		wantHosts     []sweepHost
+		wantStatus    string // Recorded by the sweep, "" if the host goes on to identification
+		wantNonCamera bool
+	}{
+		{
+			name:      "open port",
+			ip:        "127.0.0.1",
+			ports:     []int{openPort, closedPort},
+			wantHosts: []sweepHost{{IP: "127.0.0.1", OpenPorts: []int{openPort}}},
+		},
+		{
+			name:          "every port refused",
+			ip:            "127.0.0.1",
+			ports:         []int{closedPort},
+			wantStatus:    scanStatusClosed,
+			wantNonCamera: true,
+		},
+		{
+			// TEST-NET-1 (RFC 5737): never answers
+			name:       "no answer",
+			ip:         "192.0.2.1",
+			ports:      []int{openPort},
+			wantStatus: scanStatusNoResponse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := presetAdaptiveScanConfig("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := &scanOptions{adaptive: config, probeTimeout: 200 * time.Millisecond}
+			for _, port := range tt.ports {
+				opts.endpoints = append(opts.endpoints, ScanEndpoint{Scheme: "http", Port: port})
+			}
+
+			scan := newTestScan(1)
+			client, _ := scan.subscribe(nil)
+
+			hosts := runTCPSweep(context.Background(), scan, []string{tt.ip}, opts)
+			if !reflect.DeepEqual(hosts, tt.wantHosts) {
+				t.Errorf("hosts = %v, want %v", hosts, tt.wantHosts)
+			}
+
+			progress := scan.Snapshot()
+			if tt.wantStatus == "" {
+				if progress.ScannedCount != 0 {
+					t.Errorf("scanned = %d before identification, want 0", progress.ScannedCount)
+				}
+				return
+			}
+
+			if progress.ScannedCount != 1 {
+				t.Fatalf("scanned = %d, want 1", progress.ScannedCount)
+			}
+			event := <-client.send
+			if event.IP != tt.ip || event.Status != tt.wantStatus {
+				t.Errorf("event = %s %s, want %s %s", event.IP, event.Status, tt.ip, tt.wantStatus)
+			}
+			if (event.NonCamera != nil) != tt.wantNonCamera {
+				t.Fatalf("non-camera = %v, want reported: %v", event.NonCamera, tt.wantNonCamera)
+			}
+			if tt.wantNonCamera && event.NonCamera["status"] != tt.wantStatus {
+				t.Errorf("non-camera status = %v, want %s", event.NonCamera["status"], tt.wantStatus)
+			}
+		})
+	}
+}
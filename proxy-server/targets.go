@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxScanTargets caps the number of IPs one scan may expand to (a /16)
+	maxScanTargets = 65536
+	// minScanPrefix is the largest CIDR (smallest prefix length) a scan target may be
+	minScanPrefix = 16
+	// maxHostnameSpecs caps the hostname targets and exclusions of one scan (one DNS lookup each)
+	maxHostnameSpecs = 256
+	// hostnameLookupTimeout bounds DNS resolution of all hostname specs together
+	hostnameLookupTimeout = 5 * time.Second
+)
+
+// lookupIPAddr resolves hostname targets (replaced in tests)
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// ipRange is an inclusive range of IPv4 addresses in integer form
+type ipRange struct {
+	first, last uint32
+}
+
+// targetSpec is a parsed target or exclusion: an address range, or a hostname to resolve
+type targetSpec struct {
+	raw      string
+	hostname string // Set for hostname specs, which have no range until resolved
+	ipRange
+}
+
+// hostnameLookup is the outcome of resolving one hostname spec
+type hostnameLookup struct {
+	ips []uint32
+	err error
+}
+
+// expandScanTargets turns target specs into a de-duplicated IP list, minus exclusions
+// Each spec is an IPv4 address, a CIDR (e.g. 192.168.1.0/24), a dash range
+// (192.168.1.10-192.168.1.50 or 192.168.1.10-50) or a hostname (which may contain dashes)
+// Only targets are size-limited; exclusions may be any size (e.g. 10.0.0.0/8)
+func expandScanTargets(ctx context.Context, targets, exclude []string) ([]string, int, error) {
+	targetSpecs := make([]targetSpec, 0, len(targets))
+	for _, raw := range targets {
+		spec, err := parseTargetSpec(raw, true)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid target %q: %w", raw, err)
+		}
+		targetSpecs = append(targetSpecs, spec)
+	}
+	exclusionSpecs := make([]targetSpec, 0, len(exclude))
+	for _, raw := range exclude {
+		spec, err := parseTargetSpec(raw, false)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid exclusion %q: %w", raw, err)
+		}
+		exclusionSpecs = append(exclusionSpecs, spec)
+	}
+
+	lookups, err := resolveHostnames(ctx, append(append([]targetSpec(nil), targetSpecs...), exclusionSpecs...))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var exclusions []ipRange
+	for _, spec := range exclusionSpecs {
+		ranges, err := spec.ranges(lookups)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid exclusion %q: %w", spec.raw, err)
+		}
+		exclusions = append(exclusions, ranges...)
+	}
+	exclusions = mergeRanges(exclusions)
+
+	seen := make(map[uint32]bool)
+	var ips []string
+	skipped := 0
+	add := func(ip uint32) error {
+		if seen[ip] {
+			return nil
+		}
+		seen[ip] = true
+		if rangesContain(exclusions, ip) {
+			skipped++
+			return nil
+		}
+		if len(ips) >= maxScanTargets {
+			return fmt.Errorf("scan exceeds %d targets", maxScanTargets)
+		}
+		ips = append(ips, uint32ToIP(ip).String())
+		return nil
+	}
+	for _, spec := range targetSpecs {
+		ranges, err := spec.ranges(lookups)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid target %q: %w", spec.raw, err)
+		}
+		for _, r := range ranges {
+			if err := addRange(r.first, r.last, add); err != nil {
+				return nil, 0, fmt.Errorf("invalid target %q: %w", spec.raw, err)
+			}
+		}
+	}
+
+	return ips, skipped, nil
+}
+
+// parseTargetSpec parses one target or exclusion spec
+// Targets are limited to a /16 and skip CIDR network and broadcast addresses;
+// exclusions cover exactly what they name, at any size
+func parseTargetSpec(raw string, isTarget bool) (targetSpec, error) {
+	spec := strings.TrimSpace(raw)
+	if spec == "" {
+		return targetSpec{}, fmt.Errorf("empty target")
+	}
+
+	// A spec is only a range when the part before the dash is an IPv4 address,
+	// so hostnames like axis-cam-01.local fall through to DNS
+	start, end, hasDash := strings.Cut(spec, "-")
+	startIP := net.ParseIP(strings.TrimSpace(start)).To4()
+
+	switch {
+	case strings.Contains(spec, "/"):
+		_, network, err := net.ParseCIDR(spec)
+		if err != nil {
+			return targetSpec{}, err
+		}
+		if network.IP.To4() == nil {
+			return targetSpec{}, fmt.Errorf("only IPv4 subnets are supported")
+		}
+		ones, _ := network.Mask.Size()
+		if isTarget && ones < minScanPrefix {
+			return targetSpec{}, fmt.Errorf("subnet larger than /%d", minScanPrefix)
+		}
+
+		first := ipToUint32(network.IP)
+		last := first | ^binary.BigEndian.Uint32(network.Mask)
+		if isTarget && ones <= 30 {
+			// Skip network and broadcast addresses
+			first++
+			last--
+		}
+		return targetSpec{raw: raw, ipRange: ipRange{first, last}}, nil
+
+	case hasDash && startIP != nil:
+		end = strings.TrimSpace(end)
+		endIP := net.ParseIP(end).To4()
+		if endIP == nil {
+			// Short form: 192.168.1.10-50
+			lastOctet, err := strconv.Atoi(end)
+			if err != nil || lastOctet < 0 || lastOctet > 255 {
+				return targetSpec{}, fmt.Errorf("invalid range end")
+			}
+			endIP = net.IPv4(startIP[0], startIP[1], startIP[2], byte(lastOctet)).To4()
+		}
+
+		first, last := ipToUint32(startIP), ipToUint32(endIP)
+		if last < first {
+			return targetSpec{}, fmt.Errorf("range end before start")
+		}
+		if isTarget && last-first >= maxScanTargets {
+			return targetSpec{}, fmt.Errorf("range exceeds %d addresses", maxScanTargets)
+		}
+		return targetSpec{raw: raw, ipRange: ipRange{first, last}}, nil
+
+	default:
+		if ip := net.ParseIP(spec); ip != nil {
+			if ip.To4() == nil {
+				return targetSpec{}, fmt.Errorf("only IPv4 addresses are supported")
+			}
+			n := ipToUint32(ip)
+			return targetSpec{raw: raw, ipRange: ipRange{n, n}}, nil
+		}
+		return targetSpec{raw: raw, hostname: spec}, nil
+	}
+}
+
+// ranges returns the addresses spec covers, taking hostnames from lookups
+func (s targetSpec) ranges(lookups map[string]*hostnameLookup) ([]ipRange, error) {
+	if s.hostname == "" {
+		return []ipRange{s.ipRange}, nil
+	}
+	lookup := lookups[s.hostname]
+	if lookup.err != nil {
+		return nil, lookup.err
+	}
+	ranges := make([]ipRange, len(lookup.ips))
+	for i, ip := range lookup.ips {
+		ranges[i] = ipRange{ip, ip}
+	}
+	return ranges, nil
+}
+
+// resolveHostnames looks up every hostname in specs concurrently
+// All lookups share one deadline, so slow DNS can't stall a scan per hostname
+func resolveHostnames(ctx context.Context, specs []targetSpec) (map[string]*hostnameLookup, error) {
+	lookups := make(map[string]*hostnameLookup)
+	for _, spec := range specs {
+		if spec.hostname != "" {
+			lookups[spec.hostname] = &hostnameLookup{}
+		}
+	}
+	if len(lookups) == 0 {
+		return lookups, nil
+	}
+	if len(lookups) > maxHostnameSpecs {
+		return nil, fmt.Errorf("scan has more than %d hostnames", maxHostnameSpecs)
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, hostnameLookupTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for hostname, lookup := range lookups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lookup.ips, lookup.err = resolveHostname(lookupCtx, hostname)
+		}()
+	}
+	wg.Wait()
+
+	return lookups, nil
+}
+
+// resolveHostname returns the IPv4 addresses of hostname
+func resolveHostname(ctx context.Context, hostname string) ([]uint32, error) {
+	addrs, err := lookupIPAddr(ctx, hostname)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve hostname: %w", err)
+	}
+
+	var ips []uint32
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			ips = append(ips, ipToUint32(addr.IP))
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("hostname has no IPv4 address")
+	}
+	return ips, nil
+}
+
+// mergeRanges sorts ranges and merges overlapping or adjacent ones for rangesContain
+func mergeRanges(ranges []ipRange) []ipRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].first < ranges[j].first })
+
+	var merged []ipRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && uint64(r.first) <= uint64(merged[n-1].last)+1 {
+			if r.last > merged[n-1].last {
+				merged[n-1].last = r.last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// rangesContain reports whether ip falls in one of the merged ranges
+func rangesContain(ranges []ipRange, ip uint32) bool {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].last >= ip })
+	return i < len(ranges) && ranges[i].first <= ip
+}
+
+// addRange calls add for every address from first to last inclusive
+func addRange(first, last uint32, add func(ip uint32) error) error {
+	for ip := uint64(first); ip <= uint64(last); ip++ {
+		if err := add(uint32(ip)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ipToUint32 converts an IPv4 address to its integer form
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+// uint32ToIP converts an integer back to an IPv4 address
+func uint32ToIP(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
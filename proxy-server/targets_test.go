@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHosts stands in for DNS in the target tests
+var fakeHosts = map[string][]string{
+	"axis-cam-01.local":   {"192.168.1.20"},
+	"cam-lobby":           {"192.168.1.30", "fe80::1"},
+	"camera.example.com":  {"10.0.0.5"},
+	"ipv6-only.example":   {"fe80::2"},
+	"hanwha-cam-02.local": {"192.168.1.10"},
+}
+
+func stubLookup(t *testing.T) {
+	t.Helper()
+	previous := lookupIPAddr
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		addrs, ok := fakeHosts[host]
+		if !ok {
+			return nil, fmt.Errorf("no such host")
+		}
+		var ipAddrs []net.IPAddr
+		for _, addr := range addrs {
+			ipAddrs = append(ipAddrs, net.IPAddr{IP: net.ParseIP(addr)})
+		}
+		return ipAddrs, nil
+	}
+	t.Cleanup(func() { lookupIPAddr = previous })
+}
+
+// manyHostnames returns n distinct hostname specs
+func manyHostnames(n int) []string {
+	hostnames := make([]string, n)
+	for i := range hostnames {
+		hostnames[i] = fmt.Sprintf("cam-%d.local", i)
+	}
+	return hostnames
+}
+
+func TestExpandScanTargets(t *testing.T) {
+	stubLookup(t)
+
+	tests := []struct {
+		name        string
+		targets     []string
+		exclude     []string
+		want        []string
+		wantSkipped int
+	}{
+		{
+			name:    "single address",
+			targets: []string{"192.168.1.5"},
+			want:    []string{"192.168.1.5"},
+		},
+		{
+			name:    "CIDR skips network and broadcast",
+			targets: []string{"192.168.1.0/30"},
+			want:    []string{"192.168.1.1", "192.168.1.2"},
+		},
+		{
+			name:    "CIDR /31 keeps both addresses",
+			targets: []string{"192.168.1.4/31"},
+			want:    []string{"192.168.1.4", "192.168.1.5"},
+		},
+		{
+			name:    "CIDR /32",
+			targets: []string{"192.168.1.9/32"},
+			want:    []string{"192.168.1.9"},
+		},
+		{
+			name:    "short range",
+			targets: []string{"192.168.1.10-12"},
+			want:    []string{"192.168.1.10", "192.168.1.11", "192.168.1.12"},
+		},
+		{
+			name:    "full range across octets",
+			targets: []string{"192.168.1.254 - 192.168.2.1"},
+			want:    []string{"192.168.1.254", "192.168.1.255", "192.168.2.0", "192.168.2.1"},
+		},
+		{
+			name:    "hostname",
+			targets: []string{"camera.example.com"},
+			want:    []string{"10.0.0.5"},
+		},
+		{
+			name:    "hyphenated hostname",
+			targets: []string{"axis-cam-01.local"},
+			want:    []string{"192.168.1.20"},
+		},
+		{
+			name:    "hyphenated hostname keeps IPv4 addresses only",
+			targets: []string{"cam-lobby"},
+			want:    []string{"192.168.1.30"},
+		},
+		{
+			name:    "duplicates across specs",
+			targets: []string{"192.168.1.10-11", "192.168.1.11", "hanwha-cam-02.local"},
+			want:    []string{"192.168.1.10", "192.168.1.11"},
+		},
+		{
+			name:        "exclusions",
+			targets:     []string{"192.168.1.0/29"},
+			exclude:     []string{"192.168.1.2", "192.168.1.4-5"},
+			want:        []string{"192.168.1.1", "192.168.1.3", "192.168.1.6"},
+			wantSkipped: 3,
+		},
+		{
+			name:        "hyphenated hostname exclusion",
+			targets:     []string{"192.168.1.19-21"},
+			exclude:     []string{"axis-cam-01.local"},
+			want:        []string{"192.168.1.19", "192.168.1.21"},
+			wantSkipped: 1,
+		},
+		{
+			name:        "CIDR exclusion",
+			targets:     []string{"192.168.1.1", "10.0.0.1-3"},
+			exclude:     []string{"10.0.0.0/24"},
+			want:        []string{"192.168.1.1"},
+			wantSkipped: 3,
+		},
+		{
+			// Exclusions aren't held to the /16 target limit
+			name:        "exclusion larger than a /16",
+			targets:     []string{"10.20.30.1-2", "192.168.1.1"},
+			exclude:     []string{"10.0.0.0/8"},
+			want:        []string{"192.168.1.1"},
+			wantSkipped: 2,
+		},
+		{
+			name:        "exclusion range larger than the target limit",
+			targets:     []string{"10.0.0.1", "172.16.0.1", "192.168.1.1"},
+			exclude:     []string{"10.0.0.0-172.16.255.255"},
+			want:        []string{"192.168.1.1"},
+			wantSkipped: 2,
+		},
+		{
+			// Targets skip a CIDR's network address; an excluded CIDR covers it
+			name:        "CIDR exclusion includes network and broadcast",
+			targets:     []string{"192.168.1.0", "192.168.1.255", "192.168.2.0"},
+			exclude:     []string{"192.168.1.0/24"},
+			want:        []string{"192.168.2.0"},
+			wantSkipped: 2,
+		},
+		{
+			name:        "overlapping exclusions",
+			targets:     []string{"192.168.1.1-10"},
+			exclude:     []string{"192.168.1.2-5", "192.168.1.4-6", "192.168.1.7", "192.168.1.9/32"},
+			want:        []string{"192.168.1.1", "192.168.1.8", "192.168.1.10"},
+			wantSkipped: 7,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, skipped, err := expandScanTargets(context.Background(), tt.targets, tt.exclude)
+			if err != nil {
+				t.Fatalf("expandScanTargets: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ips = %v, want %v", got, tt.want)
+			}
+			if skipped != tt.wantSkipped {
+				t.Errorf("skipped = %d, want %d", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestExpandScanTargetsErrors(t *testing.T) {
+	stubLookup(t)
+
+	tests := []struct {
+		name    string
+		targets []string
+		exclude []string
+		wantErr string
+	}{
+		{name: "empty", targets: []string{" "}, wantErr: "empty target"},
+		{name: "IPv6 address", targets: []string{"fe80::1"}, wantErr: "only IPv4 addresses"},
+		{name: "IPv6 subnet", targets: []string{"fe80::/120"}, wantErr: "only IPv4 subnets"},
+		{name: "invalid CIDR", targets: []string{"192.168.1.0/33"}, wantErr: "invalid CIDR"},
+		{name: "subnet too large", targets: []string{"10.0.0.0/15"}, wantErr: "subnet larger than /16"},
+		{name: "range end before start", targets: []string{"192.168.1.50-10"}, wantErr: "range end before start"},
+		{name: "invalid short range end", targets: []string{"192.168.1.10-256"}, wantErr: "invalid range end"},
+		{name: "range too large", targets: []string{"10.0.0.0-10.1.0.0"}, wantErr: "range exceeds 65536 addresses"},
+		{name: "too many targets", targets: []string{"10.0.0.0/16", "10.1.0.0/16", "10.2.0.0/16"}, wantErr: "scan exceeds 65536 targets"},
+		{name: "unknown hyphenated hostname", targets: []string{"no-such-cam.local"}, wantErr: "failed to resolve hostname"},
+		{name: "hostname without IPv4", targets: []string{"ipv6-only.example"}, wantErr: "hostname has no IPv4 address"},
+		{name: "invalid exclusion", targets: []string{"192.168.1.1"}, exclude: []string{"no-such-cam.local"}, wantErr: `invalid exclusion "no-such-cam.local"`},
+		{name: "IPv6 exclusion", targets: []string{"192.168.1.1"}, exclude: []string{"fe80::/64"}, wantErr: "only IPv4 subnets"},
+		{name: "too many hostnames", targets: manyHostnames(maxHostnameSpecs + 1), wantErr: "more than 256 hostnames"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := expandScanTargets(context.Background(), tt.targets, tt.exclude)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpandScanTargetsSizeLimit(t *testing.T) {
+	// A /16 minus network and broadcast, plus two more addresses, exactly fills the limit
+	ips, _, err := expandScanTargets(context.Background(), []string{"10.0.0.0/16", "10.1.0.1-2"}, nil)
+	if err != nil {
+		t.Fatalf("expandScanTargets: %v", err)
+	}
+	if len(ips) != maxScanTargets {
+		t.Errorf("got %d targets, want %d", len(ips), maxScanTargets)
+	}
+
+	_, _, err = expandScanTargets(context.Background(), []string{"10.0.0.0/16", "10.1.0.1-3"}, nil)
+	if err == nil {
+		t.Errorf("expected error above %d targets", maxScanTargets)
+	}
+}
+
+func TestExpandScanTargetsResolvesHostnamesTogether(t *testing.T) {
+	hostnames := manyHostnames(maxHostnameSpecs)
+
+	// Every lookup waits until all of them have started, so sequential lookups would
+	// run into the deadline; each also records the deadline it was given
+	var mu sync.Mutex
+	started := 0
+	allStarted := make(chan struct{})
+	deadlines := make(map[time.Time]bool)
+	previous := lookupIPAddr
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		deadline, _ := ctx.Deadline()
+		mu.Lock()
+		deadlines[deadline] = true
+		started++
+		if started == len(hostnames) {
+			close(allStarted)
+		}
+		mu.Unlock()
+
+		select {
+		case <-allStarted:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		var index int
+		fmt.Sscanf(host, "cam-%d.local", &index)
+		return []net.IPAddr{{IP: net.IPv4(10, 0, byte(index>>8), byte(index))}}, nil
+	}
+	t.Cleanup(func() { lookupIPAddr = previous })
+
+	ips, _, err := expandScanTargets(context.Background(), hostnames, nil)
+	if err != nil {
+		t.Fatalf("expandScanTargets: %v", err)
+	}
+	if len(ips) != len(hostnames) {
+		t.Errorf("got %d targets, want %d", len(ips), len(hostnames))
+	}
+	if ips[0] != "10.0.0.0" || ips[len(ips)-1] != "10.0.0.255" {
+		t.Errorf("targets out of spec order: first %s, last %s", ips[0], ips[len(ips)-1])
+	}
+	if len(deadlines) != 1 {
+		t.Errorf("lookups ran under %d different deadlines, want one shared deadline", len(deadlines))
+	}
+}